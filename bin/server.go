@@ -58,10 +58,142 @@ func main() {
 		},
 	}
 
+	if path := sstv.GetConfig().EgressProxiesFile; len(path) > 0 {
+		egress, err := sstv.LoadEgress(path)
+		if err != nil {
+			log.Fatalf("Could not load egress proxies: %s", err)
+		}
+		runtime.Egress = egress
+	}
+
+	runtime.SavedSearches = make(map[string]string)
+	for name, q := range sstv.GetConfig().SavedSearches {
+		runtime.SavedSearches[name] = q
+	}
+	if path := sstv.GetConfig().SavedSearchesFile; len(path) > 0 {
+		searches, err := sstv.LoadSavedSearches(path)
+		if err != nil {
+			log.Fatalf("Could not load saved searches: %s", err)
+		}
+		for name, q := range searches {
+			runtime.SavedSearches[name] = q
+		}
+	}
+
+	if path := sstv.GetConfig().ChannelsFile; len(path) > 0 {
+		channels, err := sstv.LoadStaticChannels(path)
+		if err != nil {
+			log.Fatalf("Could not load channels: %s", err)
+		}
+		sstv.RegisterProvider(sstv.NewStaticProvider(channels))
+	}
+	if path := sstv.GetConfig().VirtualChannelsFile; len(path) > 0 {
+		channels, err := sstv.LoadVirtualChannels(path)
+		if err != nil {
+			log.Fatalf("Could not load virtual channels: %s", err)
+		}
+		sstv.RegisterProvider(sstv.NewVirtualProvider(channels))
+	}
+
+	// Subsystems share runtime, so each sees the others set up after it
+	if dir := sstv.GetConfig().RecordingsDir; len(dir) > 0 {
+		recorder, err := sstv.NewRecorder(&runtime, dir)
+		if err != nil {
+			log.Fatalf("Could not start recorder: %s", err)
+		}
+		runtime.Recorder = recorder
+	}
+
+	if depth := sstv.GetConfig().TimeshiftDepth; depth > 0 {
+		timeshift, err := sstv.NewTimeshift(&runtime, sstv.GetConfig().TimeshiftDir, depth)
+		if err != nil {
+			log.Fatalf("Could not start timeshift: %s", err)
+		}
+		runtime.Timeshift = timeshift
+	}
+
+	if sstv.GetConfig().ProxyMode {
+		runtime.Proxy = sstv.NewStreamProxy(&runtime, sstv.GetConfig().ProxySegments, sstv.GetConfig().ProxyIdle)
+	}
+
+	if path := sstv.GetConfig().ProfilesFile; len(path) > 0 {
+		profiles, err := sstv.LoadProfiles(path)
+		if err != nil {
+			log.Fatalf("Could not load profiles: %s", err)
+		}
+		runtime.Profiles = profiles
+	}
+
+	if interval := sstv.GetConfig().HealthCheckInterval; interval > 0 {
+		runtime.Health = sstv.NewHealthChecker(&runtime, interval, sstv.GetConfig().HealthCheckHistory, sstv.GetConfig().HealthCheckProviders)
+	}
+
+	if interval := sstv.GetConfig().EPGGrabInterval; interval > 0 {
+		runtime.Grabbers = sstv.NewEPGGrabbers(&runtime, sstv.GetConfig().EPGGrabURL, interval)
+	}
+
+	runtime.Sessions = sstv.NewSessionTracker(&runtime, sstv.GetConfig().ClientStreamLimit,
+		sstv.GetConfig().AccountConnectionLimit, sstv.GetConfig().SessionRedirectTTL, sstv.GetConfig().ProxyIdle)
+
+	// Started once runtime is complete, as they read it from other goroutines
+	if runtime.Recorder != nil {
+		sstv.OnFeedRefresh(runtime.Recorder.ApplyRules)
+		go runtime.Recorder.Run()
+	}
+	if runtime.Timeshift != nil {
+		runtime.Timeshift.Keep(sstv.GetConfig().TimeshiftChannels)
+		go runtime.Timeshift.Run()
+	}
+	if runtime.Proxy != nil {
+		go runtime.Proxy.Run()
+	}
+	if runtime.Health != nil {
+		go runtime.Health.Run()
+	}
+	if runtime.Grabbers != nil {
+		go runtime.Grabbers.Run()
+	}
+
 	r.HandleFunc("/c", sstv.ServeChanList(runtime))
+	r.HandleFunc("/c/search", sstv.ServeSearchPlaylist(runtime))
+	r.HandleFunc("/c/saved/{name}", sstv.ServeSavedSearchPlaylist(runtime))
 	r.HandleFunc("/c/{chan}", sstv.ServeChanRedir(runtime))
 	r.HandleFunc("/ruv/{chan}", sstv.ServeRuvRedir(runtime))
+	r.HandleFunc("/v/{chan}", sstv.ServeVirtualRedir(runtime))
+	r.HandleFunc("/p/{provider}/{chan}", sstv.ServeProviderRedir(runtime))
 	r.HandleFunc("/g", sstv.ServeEPG(runtime))
+	r.HandleFunc("/api/channels", sstv.ServeAPIChannels(runtime))
+	r.HandleFunc("/api/channels/{id}/schedule", sstv.ServeAPISchedule(runtime))
+	r.HandleFunc("/api/nownext", sstv.ServeAPINowNext(runtime))
+	r.HandleFunc("/api/programmes", sstv.ServeAPIProgrammes(runtime))
+	r.HandleFunc("/api/search", sstv.ServeAPISearch(runtime))
+	r.HandleFunc("/api/recordings", sstv.ServeAPIRecordings(runtime))
+	r.HandleFunc("/api/recordings/{id}", sstv.ServeAPIRecording(runtime))
+	r.HandleFunc("/api/recording-rules", sstv.ServeAPIRecordingRules(runtime))
+	r.HandleFunc("/api/recording-rules/{id}", sstv.ServeAPIRecordingRule(runtime))
+	r.HandleFunc("/api/sessions", sstv.ServeAPISessions(runtime))
+	r.HandleFunc("/api/sessions/{id}", sstv.ServeAPISession(runtime))
+	r.HandleFunc("/api/feed-stats", sstv.ServeAPIFeedStats(runtime))
+	r.HandleFunc("/api/health", sstv.ServeAPIHealth(runtime))
+	r.HandleFunc("/api/health/{id}", sstv.ServeAPIChannelHealth(runtime))
+	r.HandleFunc("/vod", sstv.ServeVODList(runtime))
+	r.HandleFunc("/vod/{id:[0-9a-f]+}.m3u8", sstv.ServeVODPlaylist(runtime))
+	r.HandleFunc("/vod/{id:[0-9a-f]+}.ts", sstv.ServeVODFile(runtime))
+	r.HandleFunc("/timeshift/{provider}/{chan}.m3u8", sstv.ServeTimeshiftPlaylist(runtime))
+	r.HandleFunc("/timeshift/{provider}/{chan}/{seq:[0-9]+}.ts", sstv.ServeTimeshiftSegment(runtime))
+	r.HandleFunc("/proxy/{provider}/{chan}.m3u8", sstv.ServeProxyPlaylist(runtime))
+	r.HandleFunc("/proxy/{provider}/{chan}/{seq:[0-9]+}.ts", sstv.ServeProxySegment(runtime))
+	r.HandleFunc("/ts/{chan}", sstv.ServeTSStream(runtime))
+	r.HandleFunc("/ts/{provider}/{chan}", sstv.ServeTSStream(runtime))
+	r.HandleFunc("/player_api.php", sstv.ServeXtreamPlayerAPI(runtime))
+	r.HandleFunc("/get.php", sstv.ServeXtreamPlaylist(runtime))
+	r.HandleFunc("/xmltv.php", sstv.ServeXtreamEPG(runtime))
+	r.HandleFunc("/live/{username}/{password}/{id:[0-9]+}.{ext:ts|m3u8}", sstv.ServeXtreamLive(runtime))
+	r.HandleFunc("/movie/{username}/{password}/{id:[0-9]+}.{ext}", sstv.ServeXtreamMovie(runtime))
+	r.HandleFunc("/device.xml", sstv.ServeDeviceDescription(runtime))
+	r.HandleFunc("/discover.json", sstv.ServeHDHomeRunDiscover(runtime))
+	r.HandleFunc("/lineup.json", sstv.ServeHDHomeRunLineup(runtime))
+	r.HandleFunc("/lineup_status.json", sstv.ServeHDHomeRunLineupStatus(runtime))
 	r.HandleFunc("/ready/", k8sProbe)
 
 	addr := fmt.Sprintf(":%s", sstv.GetConfig().Port)
@@ -72,6 +204,16 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	if sstv.GetConfig().SSDP {
+		ssdp := sstv.NewSSDPResponder(sstv.GetConfig().Port, sstv.GetConfig().BaseURL)
+		srv.RegisterOnShutdown(ssdp.Close)
+		go func() {
+			if err := ssdp.Run(); err != nil {
+				log.Printf("SSDP stopped: %s", err)
+			}
+		}()
+	}
+
 	// Start Server
 	go func() {
 		log.Printf("Starting Server on %s", addr)
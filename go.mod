@@ -1,6 +1,6 @@
 module github.com/sindrig/sstv-go
 
-go 1.13
+go 1.20
 
 require (
 	github.com/go-redis/redis v6.15.6+incompatible
@@ -8,7 +8,6 @@ require (
 	github.com/google/go-cmp v0.4.0 // indirect
 	github.com/gorilla/mux v1.7.3
 	github.com/kelseyhightower/envconfig v1.4.0
-	github.com/mitchellh/mapstructure v1.1.2
 	github.com/pkg/errors v0.9.1 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gotest.tools v2.2.0+incompatible
@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"regexp"
-	"strconv"
 
 	"github.com/gorilla/mux"
 )
@@ -16,61 +14,138 @@ func ServeChanList(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Received chanList request from %s\n", r.RemoteAddr)
 
-		baseURL := GetConfig().BaseURL
-
-		if len(baseURL) == 0 {
-			baseURL = fmt.Sprintf("http://%s", r.Host)
-		}
+		baseURL := getBaseURL(r)
 		log.Printf("Using base url: '%s'", baseURL)
 
 		baseChan := make(chan string)
-		go getBasem3u(baseChan, baseURL)
+		go getBasem3u(runtime, baseChan, baseURL)
 
-		epgChan := make(chan SSEpg)
-		go getSsJSONEpg(runtime, epgChan)
+		emitChannelDataToWriter(baseChan, w)
+	}
+}
+
+// ServeSearchPlaylist Serve a m3u playlist of channels airing events matching q
+func ServeSearchPlaylist(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		if len(tokenize(q)) == 0 {
+			w.WriteHeader(400)
+			w.Write([]byte("Missing search query q"))
+			return
+		}
+		c := make(chan string)
+		go getSearchm3u(runtime, c, getBaseURL(r), q, q)
+		emitChannelDataToWriter(c, w)
+	}
+}
 
-		chanChan := make(chan string)
+// ServeSavedSearchPlaylist Serve a m3u playlist for a configured saved search
+func ServeSavedSearchPlaylist(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		q, ok := runtime.SavedSearches[name]
+		if !ok {
+			w.WriteHeader(404)
+			w.Write([]byte(fmt.Sprintf("No saved search found for %s", name)))
+			return
+		}
+		c := make(chan string)
+		go getSearchm3u(runtime, c, getBaseURL(r), name, q)
+		emitChannelDataToWriter(c, w)
+	}
+}
 
-		go func() {
-			defer close(chanChan)
-			for _, channel := range (<-epgChan).Channels {
-				chanID := fmt.Sprintf("SSTV-%s", channel.Number)
-				chanChan <- fmt.Sprintf("#EXTINF:-1 tvg-id=\"%s\" tvg-logo=\"%s\", %s\n", chanID, channel.Img, channel.Name)
-				chanChan <- fmt.Sprintf("%s/c/%s\n", baseURL, channel.Number)
-			}
-		}()
+// getBaseURL Configured base url, or guessed from the request
+func getBaseURL(r *http.Request) string {
+	baseURL := GetConfig().BaseURL
+	if len(baseURL) == 0 {
+		baseURL = fmt.Sprintf("http://%s", r.Host)
+	}
+	return baseURL
+}
 
-		emitChannelDataToWriter(baseChan, w)
-		emitChannelDataToWriter(chanChan, w)
+// writeStreamError Respond with the status matching a stream resolution error
+func writeStreamError(w http.ResponseWriter, provider Provider, channel string, err error) {
+	switch err {
+	case ErrUnknownChannel:
+		w.WriteHeader(404)
+		w.Write([]byte(fmt.Sprintf("No channel found for %s", channel)))
+	case ErrNotAiring:
+		w.WriteHeader(503)
+		w.Write([]byte(fmt.Sprintf("Nothing airing on %s", channel)))
+	default:
+		log.Printf("Could not resolve %s stream for %s: %s", provider.Name(), channel, err)
+		w.WriteHeader(502)
+		w.Write([]byte(err.Error()))
 	}
 }
 
-// ServeChanRedir Redirect to authenticated m3u8 stream
-func ServeChanRedir(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+// serveProviderStream Redirect to the stream url resolved by a provider
+func serveProviderStream(runtime RuntimeUtils, w http.ResponseWriter, r *http.Request, provider Provider, channel string) {
+	var url string
+	mode := SessionRedirect
+	if runtime.Proxy != nil {
+		// Viewers share one upstream session through the local playlist, which resolves the stream when it opens
+		url = proxyPlaylistURL(getBaseURL(r), provider, channel)
+		mode = SessionProxy
+	} else {
+		log.Printf("Resolving %s stream for chan %s...", provider.Name(), channel)
+		var err error
+		if url, err = provider.StreamURL(runtime, channel); err != nil {
+			writeStreamError(w, provider, channel, err)
+			return
+		}
+	}
+	if runtime.Sessions != nil {
+		if _, err := runtime.Sessions.Start(r, provider, channel, mode); err != nil {
+			writeStreamLimit(w, channel)
+			return
+		}
+	}
+	log.Printf("Url created... %s", url)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// ServeProviderRedir Redirect to the stream of any registered provider
+func ServeProviderRedir(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c := make(chan string)
-		go getAuth(runtime, c)
-		chanStr := mux.Vars(r)["chan"]
-		channel, err := strconv.Atoi(chanStr)
-		if err != nil {
+		vars := mux.Vars(r)
+		provider, ok := GetProvider(vars["provider"])
+		if !ok {
 			w.WriteHeader(404)
-			w.Write([]byte(fmt.Sprintf("No channel found for %s", chanStr)))
+			w.Write([]byte(fmt.Sprintf("No provider found for %s", vars["provider"])))
 			return
 		}
-		log.Printf("Creating url for chan %d...", channel)
-		url := fmt.Sprintf("https://deu-uk1.SmoothStreams.tv/viewss/ch%02dq1.stream/playlist.m3u8?wmsAuthSign=%s", channel, <-c)
-		log.Printf("Url created... %s", url)
-		http.Redirect(w, r, url, http.StatusFound)
+		serveProviderStream(runtime, w, r, provider, vars["chan"])
+	}
+}
+
+// ServeChanRedir Redirect to authenticated m3u8 stream
+func ServeChanRedir(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, _ := GetProvider("sstv")
+		serveProviderStream(runtime, w, r, provider, mux.Vars(r)["chan"])
 	}
 }
 
 // ServeRuvRedir Redirect to geoblocked ruv m3u8 stream
 func ServeRuvRedir(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		chanStr := mux.Vars(r)["chan"]
-		c := make(chan string)
-		go getRuvStream(c, chanStr)
-		http.Redirect(w, r, <-c, http.StatusFound)
+		provider, _ := GetProvider("ruv")
+		serveProviderStream(runtime, w, r, provider, mux.Vars(r)["chan"])
+	}
+}
+
+// ServeVirtualRedir Redirect to the sstv channel airing an event matching a virtual channel
+func ServeVirtualRedir(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := GetProvider("virtual")
+		if !ok {
+			w.WriteHeader(404)
+			w.Write([]byte("No virtual channels configured"))
+			return
+		}
+		serveProviderStream(runtime, w, r, provider, mux.Vars(r)["chan"])
 	}
 }
 
@@ -80,15 +155,9 @@ func ServeEPG(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
 		baseChan := make(chan string)
 		go getBaseEpg(baseChan)
 
-		epgChan := make(chan SSEpg)
-		go getSsJSONEpg(runtime, epgChan)
-
 		base, _ := <-baseChan
-		re := regexp.MustCompile(`\r?\n`)
-		base = re.ReplaceAllString(base, "")
-
-		var resultEpg EPG
-		if err := xml.Unmarshal([]byte(string(base)), &resultEpg); err != nil {
+		resultEpg, err := parseBaseEpg(base)
+		if err != nil {
 			log.Printf("Could not unmarshal: %s", base)
 			w.Write([]byte(base))
 			return
@@ -96,48 +165,22 @@ func ServeEPG(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
 
 		log.Printf("Got channels: %d", len(resultEpg.Channel))
 
-		epgData := <-epgChan
-		timeFormat := "20060102150405 +0000"
-
-		for _, channel := range epgData.Channels {
-			chanID := fmt.Sprintf("SSTV-%s", channel.Number)
-			resultEpg.Channel = append(resultEpg.Channel, Channel{
-				ID: chanID,
-				DisplayName: TextLang{
-					Lang: "en",
-					Text: channel.Name,
-				},
-			})
-			for _, event := range channel.Events {
-				prog := Programme{
-					Title: TextLang{
-						Text: event.Name,
-						Lang: "en",
-					},
-					Channel: chanID,
-					Start:   event.Start.Format(timeFormat),
-					Stop:    event.Stop.Format(timeFormat),
-				}
-				if len(event.Description) > 0 {
-					prog.Desc = &TextLang{
-						Text: event.Description,
-						Lang: "en",
-					}
-				}
-				resultEpg.Programme = append(resultEpg.Programme, prog)
-			}
-		}
+		appendProviderEpgs(runtime, &resultEpg)
 		log.Printf("Got channels: %d", len(resultEpg.Channel))
-		result, err := xml.MarshalIndent(resultEpg, "", "    ")
-		if err != nil {
-			log.Printf("Could not marshal result: %s", err)
-			w.WriteHeader(500)
-			w.Write([]byte(err.Error()))
-		} else {
-			w.Header().Set("Content-Type", "text/xml")
-			w.Write([]byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"))
-			w.Write(result)
-		}
+		writeEPG(w, resultEpg)
+	}
+}
 
+// writeEPG Respond with epg as an XMLTV document
+func writeEPG(w http.ResponseWriter, epg EPG) {
+	result, err := xml.MarshalIndent(epg, "", "    ")
+	if err != nil {
+		log.Printf("Could not marshal result: %s", err)
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
 	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"))
+	w.Write(result)
 }
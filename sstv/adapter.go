@@ -2,101 +2,210 @@ package sstv
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
-	"sort"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
-
-	"github.com/mitchellh/mapstructure"
 )
 
-// getSsJSONEpg Get the EPG from SS
-func getSsJSONEpg(runtime RuntimeUtils, c chan SSEpg) {
-	defer close(c)
+// ssFeedDecoders Decoders of the sstv feed formats
+var ssFeedDecoders = map[string]func([]byte) (SSEpg, SSFeedStats, error){
+	"json":  decodeSsJSONFeed,
+	"xmltv": decodeSsXMLTVFeed,
+}
+
+// getSsFeed Raw sstv feed of a format, from the cache or fetched. fresh tells if it was fetched
+func getSsFeed(runtime RuntimeUtils, format string) (feed string, fresh bool, err error) {
+	cfg := GetConfig()
 	cacheKey := "ssJsonEpgFeed"
-	var jsonData map[string]interface{}
-	jsonFeed, err := runtime.Cache.Get(cacheKey)
-	if err == nil && len(jsonFeed) > 0 {
-		log.Println("Got jsonFeed from cache")
-	} else {
-		u, err := url.Parse(GetConfig().JSONTVUrl)
+	path := cfg.SSJSONFeed
+	if format == "xmltv" {
+		cacheKey = "ssXMLTVEpgFeed"
+		path = cfg.SSXMLTVFeed
+	}
+	feed, err = runtime.Cache.Get(cacheKey)
+	if err == nil && len(feed) > 0 {
+		log.Printf("Got %s feed from cache", format)
+		return feed, false, nil
+	}
+	u, err := url.Parse(cfg.JSONTVUrl)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid feed url: %s", err)
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid %s feed path: %s", format, err)
+	}
+	feedChan := make(chan string)
+	go getFile(feedChan, u.ResolveReference(ref).String())
+	feed, _ = <-feedChan
+	if len(feed) == 0 {
+		return "", false, fmt.Errorf("could not fetch %s feed", format)
+	}
+	cache(runtime.Cache, cacheKey, feed, 1)
+	return feed, true, nil
+}
+
+// getSsEpg Get the EPG from SS, from the first feed format that fetches and parses.
+// With SSFeedCombine, later formats fill in channels and events the earlier ones are missing
+func getSsEpg(runtime RuntimeUtils, c chan SSEpg) {
+	defer close(c)
+	cfg := GetConfig()
+	var epg SSEpg
+	var feeds []string
+	decoded, fresh := false, false
+	for _, format := range cfg.SSFeedFormats {
+		decode, ok := ssFeedDecoders[format]
+		if !ok {
+			log.Printf("Unknown sstv feed format %s", format)
+			continue
+		}
+		feed, isFresh, err := getSsFeed(runtime, format)
 		if err != nil {
-			log.Fatal("Could not parse json tv url...")
+			log.Printf("Could not get sstv %s feed: %s", format, err)
+			continue
+		}
+		formatEpg, stats, err := decode([]byte(feed))
+		if err != nil {
+			log.Printf("Could not decode sstv %s feed: %s", format, err)
+			continue
+		}
+		correctSsEpg(formatEpg, sourceTimeCorrection(TimeSourceSSTV))
+		if isFresh {
+			stats.Format = format
+			recordSsFeedStats(stats)
 		}
-		feed, _ := url.Parse("feed-new.json")
-		feedChan := make(chan string)
-		go getFile(feedChan, u.ResolveReference(feed).String())
+		fresh = fresh || isFresh
+		feeds = append(feeds, feed)
+		if decoded {
+			epg = mergeSsEpg(epg, formatEpg)
+		} else {
+			epg = formatEpg
+			decoded = true
+		}
+		if !cfg.SSFeedCombine {
+			break
+		}
+	}
+	// Placeholders for gaps are only added to the XMLTV output, not to the events searches and rules see
+	normalization := epgNormalization()
+	normalization.FillGaps = false
+	normalizeSsEpg(epg, normalization)
+	if decoded && fresh {
+		notifyFeedRefresh(runtime, strings.Join(feeds, "\n"), epg)
+	}
+
+	c <- epg
 
-		jsonFeed, _ = <-feedChan
-		go cache(runtime.Cache, cacheKey, jsonFeed, 1)
+}
+
+// playlistChannels Channels of a provider as listed in playlists
+func playlistChannels(runtime RuntimeUtils, p Provider) ([]ProviderChannel, error) {
+	if playlistProvider, ok := p.(PlaylistProvider); ok {
+		return playlistProvider.PlaylistChannels(runtime)
 	}
+	return p.Channels(runtime)
+}
 
-	var epg SSEpg
-	if err := json.Unmarshal([]byte(string(jsonFeed)), &jsonData); err != nil {
-		log.Printf("Could not unmarshal: %s\n\n%s", err, jsonFeed)
-	} else {
-		data := jsonData["data"].(map[string]interface{})
-
-		for _, channelI := range data {
-			channel := channelI.(map[string]interface{})
-			var events []SSEpgEvent
-			switch evs := channel["events"].(type) {
-			case map[string]interface{}:
-				for _, eventI := range evs {
-					var event JSONEvent
-					mapstructure.Decode(eventI, &event)
-					// event := eventI.(map[string]string)
-					startTime, _ := epochToTime(event.Time)
-					dur, _ := time.ParseDuration(fmt.Sprintf("%sm", event.Runtime))
-					events = append(events, SSEpgEvent{
-						Name:        event.Name,
-						Description: event.Description,
-						Start:       startTime,
-						Stop:        startTime.Add(dur),
-					})
-				}
+// getBasem3u m3u header followed by the channels of every provider
+func getBasem3u(runtime RuntimeUtils, c chan string, baseURL string) {
+	defer close(c)
+	c <- fmt.Sprintf("#EXTM3U x-tvg-url=\"%s/g\"\n", baseURL)
+	// Unhealthy channels go last when the health policy reorders
+	policy := GetConfig().HealthPolicy
+	var unhealthy []string
+	for _, p := range Providers() {
+		channels, err := playlistChannels(runtime, p)
+		if err != nil {
+			log.Printf("Provider %s: could not list channels: %s", p.Name(), err)
+			continue
+		}
+		for _, channel := range channels {
+			name, ok := healthPlaylistName(runtime, channel, policy)
+			if !ok {
+				continue
 			}
-
-			epg.Channels = append(epg.Channels, SSEpgChannel{
-				Number: channel["number"].(string),
-				Name:   channel["name"].(string),
-				Img:    channel["img"].(string),
-				Events: events,
-			})
+			entry := fmt.Sprintf("#EXTINF:-1 tvg-id=\"%s\" tvg-logo=\"%s\"%s, %s\n%s%s\n",
+				m3uAttr(channel.ID), m3uAttr(channel.Logo), catchupAttributes(runtime, baseURL, p, channel), m3uTitle(name),
+				playerHeaderHints(runtime, channel.Headers), providerChannelURL(baseURL, p, channel))
+			if policy == HealthPolicyReorder && runtime.Health != nil && runtime.Health.Unhealthy(channel.ID) {
+				unhealthy = append(unhealthy, entry)
+				continue
+			}
+			c <- entry
 		}
-		sort.Slice(epg.Channels, func(i, j int) bool {
-			a, err1 := strconv.Atoi(epg.Channels[i].Number)
-			b, err2 := strconv.Atoi(epg.Channels[j].Number)
-			return err1 == nil && err2 == nil && a < b
+	}
+	for _, entry := range unhealthy {
+		c <- entry
+	}
+}
+
+// ssEpgToXMLTV Convert the sstv EPG to XMLTV channels and programmes
+func ssEpgToXMLTV(epgData SSEpg) EPG {
+	var result EPG
+	normalization := epgNormalization()
+
+	for _, channel := range epgData.Channels {
+		chanID := ssChannelID(channel.Number)
+		result.Channel = append(result.Channel, Channel{
+			ID: chanID,
+			DisplayName: TextLang{
+				Lang: "en",
+				Text: channel.Name,
+			},
 		})
+		for _, event := range normalizeSsEvents(channel.Events, normalization) {
+			result.Programme = append(result.Programme, ssEventToProgramme(chanID, event))
+		}
 	}
+	return result
+}
 
-	c <- epg
+// ssEventToProgramme Convert a sstv event to an XMLTV programme on chanID
+func ssEventToProgramme(chanID string, event SSEpgEvent) Programme {
+	zone := epgLocation()
+	prog := Programme{
+		Title: TextLang{
+			Text: event.Name,
+			Lang: "en",
+		},
+		Channel: chanID,
+		Start:   formatXMLTVTime(event.Start, zone),
+		Stop:    formatXMLTVTime(event.Stop, zone),
+	}
+	if len(event.Description) > 0 {
+		prog.Desc = &TextLang{
+			Text: event.Description,
+			Lang: "en",
+		}
+	}
+	if len(event.Category) > 0 {
+		prog.Category = &TextLang{
+			Text: event.Category,
+			Lang: "en",
+		}
+	}
+	return prog
+}
 
+// ssChannelID tvg-id for a sstv channel number
+func ssChannelID(number string) string {
+	return fmt.Sprintf("SSTV-%s", number)
 }
 
-// getBasem3u Base m3u with header and static channels
-func getBasem3u(c chan string, baseURL string) {
-	defer close(c)
-	c <- fmt.Sprintf("#EXTM3U x-tvg-url=\"%s/g\"\n", baseURL)
-	c <- "#EXTINF:-1 tvg-id=\"RÚV\" tvg-logo=\"http://iptv.irdn.is/images/ruv.png\", RÚV\n"
-	c <- fmt.Sprintf("%s/ruv/%s\n", baseURL, "ruv")
-	c <- "#EXTINF:-1 tvg-id=\"RÚV Íþróttir\" tvg-logo=\"http://iptv.irdn.is/images/ruv2.png\", RÚV Íþróttir\n"
-	c <- fmt.Sprintf("%s/ruv/%s\n", baseURL, "ruv2")
-	c <- "#EXTINF:-1 tvg-id=\"N4\" tvg-logo=\"http://iptv.irdn.is/images/n4.png\", N4\n"
-	c <- "http://tv.vodafoneplay.is/n4/index.m3u8\n"
-	c <- "#EXTINF:-1 tvg-id=\"Stöð 2\" tvg-logo=\"http://iptv.irdn.is/images/stod2.png\", Stöð 2\n"
-	c <- "http://visirlive.365cdn.is/hls-live/stod2.smil/playlist.m3u8\n"
-	c <- "#EXTINF:-1 tvg-id=\"Stöð 2 Sport\" tvg-logo=\"http://iptv.irdn.is/images/stod2sport.png\", Stöð 2 Sport\n"
-	c <- "https://visirlive.365cdn.is/hls-live/straumur05.smil/playlist.m3u8\n"
-	c <- "#EXTINF:-1 tvg-id=\"Alþingi\" tvg-logo=\"http://iptv.irdn.is/images/althingi.png\", Alþingi\n"
-	c <- "http://5-226-137-173.netvarp.is/althingi_600/index.m3u8\n"
-	c <- "#EXTINF:-1 tvg-id=\"MBL\" tvg-logo=\"http://mbl.is/img/hauslogo/mbl.generic.png\", MBL\n"
-	c <- "https://k100streymi.mbl.is/enski/index.m3u8\n"
+// parseSsChannel Channel number from a /c/{chan} path segment
+func parseSsChannel(chanStr string) (int, error) {
+	channel, err := strconv.Atoi(chanStr)
+	if err != nil {
+		return 0, ErrUnknownChannel
+	}
+	return channel, nil
 }
 
 // getBaseEpg Fetch the base EPG (or only scaffold if empty)
@@ -111,7 +220,59 @@ func getBaseEpg(c chan string) {
 	}
 }
 
-// getAuth Get authentication hash for ss
+// parseBaseEpg Parse the base EPG, ignoring newlines, with its times corrected and in the EPG zone and its programmes normalized
+func parseBaseEpg(base string) (EPG, error) {
+	re := regexp.MustCompile(`\r?\n`)
+	base = re.ReplaceAllString(base, "")
+
+	var result EPG
+	err := xml.Unmarshal([]byte(base), &result)
+	if err == nil {
+		correctProgrammes(result.Programme, sourceTimeCorrection(TimeSourceBase), epgLocation())
+		result.Programme = normalizeProgrammes(result.Programme, epgNormalization(), epgLocation())
+	}
+	return result, err
+}
+
+// appendProviderEpgs Add channels and programmes of every EPGProvider to epg
+func appendProviderEpgs(runtime RuntimeUtils, epg *EPG) {
+	for _, p := range Providers() {
+		epgProvider, ok := p.(EPGProvider)
+		if !ok {
+			continue
+		}
+		providerEpg, err := epgProvider.EPG(runtime)
+		if err != nil {
+			log.Printf("Provider %s: could not get EPG: %s", p.Name(), err)
+			continue
+		}
+		epg.Channel = append(epg.Channel, providerEpg.Channel...)
+		epg.Programme = append(epg.Programme, providerEpg.Programme...)
+	}
+	appendGrabbedEpg(runtime, epg)
+}
+
+// getGuide Combined base and provider EPG, with an empty base if it can not be parsed
+func getGuide(runtime RuntimeUtils) EPG {
+	baseChan := make(chan string)
+	go getBaseEpg(baseChan)
+
+	base, _ := <-baseChan
+	epg, err := parseBaseEpg(base)
+	if err != nil {
+		log.Printf("Could not parse base EPG: %s", err)
+		epg = EPG{}
+	}
+	appendProviderEpgs(runtime, &epg)
+	return epg
+}
+
+// ssAuthURL smoothstreams login returning the hash streams are signed with
+const ssAuthURL = "https://auth.smoothstreams.tv/hash_api.php"
+
+var ssAuthClient = &http.Client{Timeout: 10 * time.Second}
+
+// getAuth Get authentication hash for ss, through the sstv egress proxy if there is one
 func getAuth(runtime RuntimeUtils, c chan string) {
 	defer close(c)
 	cfg := GetConfig()
@@ -126,7 +287,8 @@ func getAuth(runtime RuntimeUtils, c chan string) {
 		return
 	}
 
-	response, err := http.PostForm("https://auth.smoothstreams.tv/hash_api.php", url.Values{
+	client := runtime.Egress.Client("sstv", "", ssAuthClient)
+	response, err := client.PostForm(ssAuthURL, url.Values{
 		"username": {cfg.Username},
 		"password": {cfg.Password},
 		"site":     {"viewss"},
@@ -162,38 +324,3 @@ func getAuth(runtime RuntimeUtils, c chan string) {
 
 	go cache(runtime.Cache, cacheKey, auth.Hash, auth.Valid)
 }
-
-func getRuvStream(c chan string, channel string) {
-	defer close(c)
-	if GetConfig().RuvUseGeoblocked {
-		u, err := url.Parse(GetConfig().RuvAPIURL)
-		if err != nil {
-			log.Fatal("Could not parse ruv api url...")
-		}
-		query := u.Query()
-		query.Add("channel", channel)
-		u.RawQuery = query.Encode()
-
-		fileChan := make(chan string)
-		go getFile(fileChan, u.String())
-
-		resultBody, ok := <-fileChan
-		log.Printf("result: %s", resultBody)
-		if !ok {
-			log.Printf("Did not receive result from ruv stream")
-			return
-		}
-
-		var result RuvChannelResponse
-		jsonErr := json.Unmarshal([]byte(string(resultBody)), &result)
-
-		if jsonErr != nil {
-			log.Printf("RUV: jsonErr unmarshaling: %s", jsonErr)
-			return
-		}
-
-		c <- result.Result[0]
-	} else {
-		c <- fmt.Sprintf("http://ruvruv-live.hls.adaptive.level3.net/ruv/%s/index.m3u8", channel)
-	}
-}
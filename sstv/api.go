@@ -0,0 +1,348 @@
+package sstv
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultAPILimit = 100
+const maxAPILimit = 1000
+
+// farFuture Open end of time ranges
+var farFuture = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// APIChannel A channel in JSON api responses
+type APIChannel struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Logo     string `json:"logo,omitempty"`
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+}
+
+// APIProgramme A programme in JSON api responses
+type APIProgramme struct {
+	Channel     string    `json:"channel"`
+	Title       string    `json:"title"`
+	SubTitle    string    `json:"subTitle,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	Start       time.Time `json:"start"`
+	Stop        time.Time `json:"stop"`
+}
+
+// APINowNext Currently airing and next programme of a channel
+type APINowNext struct {
+	Channel APIChannel    `json:"channel"`
+	Now     *APIProgramme `json:"now"`
+	Next    *APIProgramme `json:"next"`
+}
+
+// APIPage A paginated list of items
+type APIPage struct {
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Items  interface{} `json:"items"`
+}
+
+// apiGuide Channels and programmes of all providers, programmes sorted by start
+type apiGuide struct {
+	Channels   []APIChannel
+	Programmes map[string][]APIProgramme
+}
+
+// getAPIGuide Build the api view of all providers and the combined EPG
+func getAPIGuide(runtime RuntimeUtils, baseURL string) apiGuide {
+	guide := apiGuide{Programmes: make(map[string][]APIProgramme)}
+	for _, p := range Providers() {
+		channels, err := p.Channels(runtime)
+		if err != nil {
+			log.Printf("Provider %s: could not list channels: %s", p.Name(), err)
+			continue
+		}
+		for _, channel := range channels {
+			guide.Channels = append(guide.Channels, APIChannel{
+				ID:       channel.ID,
+				Name:     channel.Name,
+				Logo:     channel.Logo,
+				Provider: p.Name(),
+				URL:      providerChannelURL(baseURL, p, channel),
+			})
+		}
+	}
+
+	for _, prog := range getGuide(runtime).Programme {
+		apiProg, err := toAPIProgramme(prog)
+		if err != nil {
+			log.Printf("Skipping programme %s on %s: %s", prog.Title.Text, prog.Channel, err)
+			continue
+		}
+		guide.Programmes[prog.Channel] = append(guide.Programmes[prog.Channel], apiProg)
+	}
+	for _, progs := range guide.Programmes {
+		sort.Slice(progs, func(i, j int) bool {
+			return progs[i].Start.Before(progs[j].Start)
+		})
+	}
+	return guide
+}
+
+func toAPIProgramme(prog Programme) (APIProgramme, error) {
+	start, err := parseXMLTVTime(prog.Start)
+	if err != nil {
+		return APIProgramme{}, err
+	}
+	stop, err := parseXMLTVTime(prog.Stop)
+	if err != nil {
+		return APIProgramme{}, err
+	}
+	result := APIProgramme{
+		Channel: prog.Channel,
+		Title:   prog.Title.Text,
+		Start:   start,
+		Stop:    stop,
+	}
+	if prog.SubTitle != nil {
+		result.SubTitle = prog.SubTitle.Text
+	}
+	if prog.Desc != nil {
+		result.Description = prog.Desc.Text
+	}
+	if prog.Category != nil {
+		result.Category = prog.Category.Text
+	}
+	return result, nil
+}
+
+// channel Look up a channel by id
+func (g apiGuide) channel(id string) (APIChannel, bool) {
+	for _, channel := range g.Channels {
+		if channel.ID == id {
+			return channel, true
+		}
+	}
+	return APIChannel{}, false
+}
+
+// filterChannels Channels of provider with q in their name or id, empty values match all
+func (g apiGuide) filterChannels(provider string, q string) []APIChannel {
+	q = strings.ToLower(q)
+	result := []APIChannel{}
+	for _, channel := range g.Channels {
+		if len(provider) > 0 && channel.Provider != provider {
+			continue
+		}
+		if len(q) > 0 && !strings.Contains(strings.ToLower(channel.Name), q) && !strings.Contains(strings.ToLower(channel.ID), q) {
+			continue
+		}
+		result = append(result, channel)
+	}
+	return result
+}
+
+// programmeMatches Whether prog matches the q and category query parameters
+func programmeMatches(prog APIProgramme, r *http.Request) bool {
+	q := strings.ToLower(r.URL.Query().Get("q"))
+	category := r.URL.Query().Get("category")
+	if len(category) > 0 && !strings.EqualFold(prog.Category, category) {
+		return false
+	}
+	if len(q) > 0 && !strings.Contains(strings.ToLower(prog.Title), q) && !strings.Contains(strings.ToLower(prog.Description), q) {
+		return false
+	}
+	return true
+}
+
+// parseAPITime Parse RFC3339 or unix epoch, using fallback when empty
+func parseAPITime(value string, fallback time.Time) (time.Time, error) {
+	if len(value) == 0 {
+		return fallback, nil
+	}
+	if t, err := epochToTime(value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseTimeRange Parse the start and end query parameters
+func parseTimeRange(r *http.Request, defaultStart time.Time, defaultEnd time.Time) (time.Time, time.Time, error) {
+	start, err := parseAPITime(r.URL.Query().Get("start"), defaultStart)
+	if err != nil {
+		return start, start, fmt.Errorf("invalid start: %s", err)
+	}
+	end, err := parseAPITime(r.URL.Query().Get("end"), defaultEnd)
+	if err != nil {
+		return start, end, fmt.Errorf("invalid end: %s", err)
+	}
+	if !end.After(start) {
+		return start, end, fmt.Errorf("end must be after start")
+	}
+	return start, end, nil
+}
+
+// paginate Apply the limit and offset query parameters to a list of length total
+func paginate(r *http.Request, total int) (APIPage, int, int, error) {
+	page := APIPage{Total: total, Limit: defaultAPILimit}
+	if limit := r.URL.Query().Get("limit"); len(limit) > 0 {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 {
+			return page, 0, 0, fmt.Errorf("invalid limit: %s", limit)
+		}
+		page.Limit = l
+	}
+	if page.Limit > maxAPILimit {
+		page.Limit = maxAPILimit
+	}
+	if offset := r.URL.Query().Get("offset"); len(offset) > 0 {
+		o, err := strconv.Atoi(offset)
+		if err != nil || o < 0 {
+			return page, 0, 0, fmt.Errorf("invalid offset: %s", offset)
+		}
+		page.Offset = o
+	}
+	from := page.Offset
+	if from > total {
+		from = total
+	}
+	to := from + page.Limit
+	if to > total {
+		to = total
+	}
+	return page, from, to, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Could not encode json response: %s", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// ServeAPIChannels List channels with logos
+func ServeAPIChannels(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channels := getAPIGuide(runtime, getBaseURL(r)).filterChannels(r.URL.Query().Get("provider"), r.URL.Query().Get("q"))
+		page, from, to, err := paginate(r, len(channels))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		page.Items = channels[from:to]
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// ServeAPINowNext Currently airing and next programme for each channel
+func ServeAPINowNext(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		at, err := parseAPITime(r.URL.Query().Get("at"), time.Now())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid at: %s", err))
+			return
+		}
+		guide := getAPIGuide(runtime, getBaseURL(r))
+		result := []APINowNext{}
+		for _, channel := range guide.filterChannels(r.URL.Query().Get("provider"), r.URL.Query().Get("q")) {
+			item := APINowNext{Channel: channel}
+			for i, prog := range guide.Programmes[channel.ID] {
+				if !prog.Stop.After(at) {
+					continue
+				}
+				if prog.Start.After(at) {
+					item.Next = &guide.Programmes[channel.ID][i]
+					break
+				}
+				if item.Now == nil {
+					item.Now = &guide.Programmes[channel.ID][i]
+				}
+			}
+			result = append(result, item)
+		}
+		page, from, to, err := paginate(r, len(result))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		page.Items = result[from:to]
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// ServeAPISchedule Full schedule for one channel
+func ServeAPISchedule(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		guide := getAPIGuide(runtime, getBaseURL(r))
+		if _, ok := guide.channel(id); !ok {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("no channel found for %s", id))
+			return
+		}
+		start, end, err := parseTimeRange(r, time.Time{}, farFuture)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		result := []APIProgramme{}
+		for _, prog := range guide.Programmes[id] {
+			if prog.Stop.After(start) && prog.Start.Before(end) && programmeMatches(prog, r) {
+				result = append(result, prog)
+			}
+		}
+		page, from, to, err := paginate(r, len(result))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		page.Items = result[from:to]
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// ServeAPIProgrammes Programmes overlapping a time range, defaulting to the next three hours
+func ServeAPIProgrammes(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		start, end, err := parseTimeRange(r, now, now.Add(3*time.Hour))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		guide := getAPIGuide(runtime, getBaseURL(r))
+		channels := r.URL.Query()["channel"]
+		result := []APIProgramme{}
+		for _, channel := range guide.filterChannels(r.URL.Query().Get("provider"), "") {
+			if len(channels) > 0 && !containsString(channels, channel.ID) {
+				continue
+			}
+			for _, prog := range guide.Programmes[channel.ID] {
+				if prog.Stop.After(start) && prog.Start.Before(end) && programmeMatches(prog, r) {
+					result = append(result, prog)
+				}
+			}
+		}
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].Start.Before(result[j].Start)
+		})
+		page, from, to, err := paginate(r, len(result))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		page.Items = result[from:to]
+		writeJSON(w, http.StatusOK, page)
+	}
+}
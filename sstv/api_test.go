@@ -0,0 +1,94 @@
+package sstv
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gotest.tools/assert"
+)
+
+type apiTestPage struct {
+	Total int
+	Items []json.RawMessage
+}
+
+func apiTestRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/channels", ServeAPIChannels(RuntimeUtils{}))
+	r.HandleFunc("/api/channels/{id}/schedule", ServeAPISchedule(RuntimeUtils{}))
+	r.HandleFunc("/api/nownext", ServeAPINowNext(RuntimeUtils{}))
+	r.HandleFunc("/api/programmes", ServeAPIProgrammes(RuntimeUtils{}))
+	return r
+}
+
+func TestAPI(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	defer withProviders(&fakeProvider{
+		name: "fake",
+		channels: []ProviderChannel{
+			{ID: "F-1", Key: "1", Name: "Sports One"},
+			{ID: "F-2", Key: "2", Name: "Sports Two"},
+		},
+		epg: EPG{Programme: []Programme{
+			fakeProgramme("F-1", "Later", now.Add(30*time.Minute), 60),
+			fakeProgramme("F-1", "Current", now.Add(-30*time.Minute), 60),
+			fakeProgramme("F-2", "Tomorrow", now.Add(24*time.Hour), 60),
+		}},
+	})()
+	r := apiTestRouter()
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		total  int
+		items  int
+	}{
+		{"TestChannels", "/api/channels", 200, 2, 2},
+		{"TestChannelsPaginated", "/api/channels?limit=1&offset=1", 200, 2, 1},
+		{"TestChannelsFiltered", "/api/channels?q=two", 200, 1, 1},
+		{"TestChannelsInvalidLimit", "/api/channels?limit=x", 400, 0, 0},
+		{"TestNowNext", "/api/nownext", 200, 2, 2},
+		{"TestSchedule", "/api/channels/F-1/schedule", 200, 2, 2},
+		{"TestScheduleUnknownChannel", "/api/channels/F-3/schedule", 404, 0, 0},
+		{"TestProgrammesDefaultRange", "/api/programmes", 200, 2, 2},
+		{"TestProgrammesFilteredByTitle", "/api/programmes?q=later", 200, 1, 1},
+		{"TestProgrammesInvalidRange", "/api/programmes?start=2&end=1", 400, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			assert.Equal(t, w.Code, tt.status)
+			if tt.status != 200 {
+				return
+			}
+			var page apiTestPage
+			assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &page))
+			assert.Equal(t, page.Total, tt.total)
+			assert.Equal(t, len(page.Items), tt.items)
+		})
+	}
+}
+
+func TestAPINowNext(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	defer withProviders(&fakeProvider{
+		name:     "fake",
+		channels: []ProviderChannel{{ID: "F-1", Key: "1", Name: "Sports One"}},
+		epg: EPG{Programme: []Programme{
+			fakeProgramme("F-1", "Later", now.Add(30*time.Minute), 60),
+			fakeProgramme("F-1", "Current", now.Add(-30*time.Minute), 60),
+		}},
+	})()
+	w := httptest.NewRecorder()
+	apiTestRouter().ServeHTTP(w, httptest.NewRequest("GET", "/api/nownext", nil))
+
+	var page struct{ Items []APINowNext }
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, page.Items[0].Now.Title, "Current")
+	assert.Equal(t, page.Items[0].Next.Title, "Later")
+}
@@ -1,23 +1,103 @@
 package sstv
 
 import (
+	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
 // Config All configuration that sstv logic should need
 type Config struct {
-	RedisURL         string `envconfig:"REDIS_URL" default:"localhost:6379"`
-	EpgBase          string `envconfig:"EPG_BASE"`
-	JSONTVUrl        string `envconfig:"JSONTVURL" default:"https://fast-guide.smoothstreams.tv/"`
-	Username         string `envconfig:"USERNAME"`
-	Password         string `envconfig:"PASSWORD"`
-	BaseURL          string `envconfig:"BASE_URL"`
-	RuvAPIURL        string `envconfig:"RUV_API_URL" default:"http://ruv.is/sites/all/themes/at_ruv/scripts/ruv-stream.php?format=json"`
-	RuvUseGeoblocked bool   `envconfig:"RUV_USE_GEO"`
-	Port             string `envconfig:"PORT" default:"80"`
+	RedisURL  string `envconfig:"REDIS_URL" default:"localhost:6379"`
+	EpgBase   string `envconfig:"EPG_BASE"`
+	JSONTVUrl string `envconfig:"JSONTVURL" default:"https://fast-guide.smoothstreams.tv/"`
+	// SSFeedFormats sstv feed formats to try in order, json and xmltv
+	SSFeedFormats []string `envconfig:"SS_FEED_FORMATS" default:"json,xmltv"`
+	// SSFeedCombine merges all formats that parse instead of stopping at the first
+	SSFeedCombine bool `envconfig:"SS_FEED_COMBINE"`
+	// SSJSONFeed and SSXMLTVFeed are resolved against JSONTVUrl
+	SSJSONFeed  string `envconfig:"SS_JSON_FEED" default:"feed-new.json"`
+	SSXMLTVFeed string `envconfig:"SS_XMLTV_FEED" default:"feed.xml"`
+	// EPGTimeZone zone EPG times are written in
+	EPGTimeZone string `envconfig:"EPG_TIME_ZONE" default:"UTC"`
+	// EPGTimeCorrections fixes sources publishing times in the wrong zone, as source:shift or source:zone
+	// for the sources base, sstv and grab, like "base:America/New_York,sstv:-1h"
+	EPGTimeCorrections map[string]string `envconfig:"EPG_TIME_CORRECTIONS"`
+	// EPGOverlapPolicy what is done with overlapping events on a channel: none, trim or merge
+	EPGOverlapPolicy string `envconfig:"EPG_OVERLAP_POLICY" default:"none"`
+	// EPGFillGaps fills gaps of at least EPGMinGap between events with EPGGapTitle placeholders,
+	// at most EPGPlaceholderLength long each
+	EPGFillGaps          bool          `envconfig:"EPG_FILL_GAPS"`
+	EPGGapTitle          string        `envconfig:"EPG_GAP_TITLE" default:"No event scheduled"`
+	EPGMinGap            time.Duration `envconfig:"EPG_MIN_GAP" default:"5m"`
+	EPGPlaceholderLength time.Duration `envconfig:"EPG_PLACEHOLDER_LENGTH" default:"2h"`
+	Username             string        `envconfig:"USERNAME"`
+	Password             string        `envconfig:"PASSWORD"`
+	BaseURL              string        `envconfig:"BASE_URL"`
+	RuvAPIURL            string        `envconfig:"RUV_API_URL" default:"http://ruv.is/sites/all/themes/at_ruv/scripts/ruv-stream.php?format=json"`
+	RuvUseGeoblocked     bool          `envconfig:"RUV_USE_GEO"`
+	// RuvCacheTTL how long a resolved ruv stream url is reused
+	RuvCacheTTL time.Duration `envconfig:"RUV_CACHE_TTL" default:"10m"`
+	Port        string        `envconfig:"PORT" default:"80"`
+	// SavedSearches maps playlist names to search queries, served as /c/saved/{name}, as name:query,name:query.
+	// Queries can not contain , or : here, use SavedSearchesFile for those
+	SavedSearches map[string]string `envconfig:"SAVED_SEARCHES"`
+	// SavedSearchesFile json object of playlist names to search queries, added to SavedSearches
+	SavedSearchesFile string `envconfig:"SAVED_SEARCHES_FILE"`
+	// SearchPlaylistWindow how soon an event must start to be included in search playlists
+	SearchPlaylistWindow time.Duration `envconfig:"SEARCH_PLAYLIST_WINDOW" default:"30m"`
+	// ChannelsFile json file with StaticChannel definitions replacing the default static channels
+	ChannelsFile string `envconfig:"CHANNELS_FILE"`
+	// SourceCooldown how long a failed channel source is skipped
+	SourceCooldown time.Duration `envconfig:"SOURCE_COOLDOWN" default:"5m"`
+	// VirtualChannelsFile json file with VirtualChannel definitions
+	VirtualChannelsFile string `envconfig:"VIRTUAL_CHANNELS_FILE"`
+	// DynamicNames names sstv playlist entries after their current or next event
+	DynamicNames         bool          `envconfig:"DYNAMIC_NAMES"`
+	DynamicNameTemplate  string        `envconfig:"DYNAMIC_NAME_TEMPLATE" default:"{{.Number}} · {{.Event}}{{if .Live}} (live){{else}} ({{.Start}}){{end}}"`
+	DynamicNameLookahead time.Duration `envconfig:"DYNAMIC_NAME_LOOKAHEAD" default:"3h"`
+	// DynamicNameEmpty what to do with channels without events: keep, mark or hide
+	DynamicNameEmpty string `envconfig:"DYNAMIC_NAME_EMPTY" default:"keep"`
+	// RecordingsDir enables recording to this directory
+	RecordingsDir        string        `envconfig:"RECORDINGS_DIR"`
+	RecordingPrePadding  time.Duration `envconfig:"RECORDING_PRE_PADDING" default:"2m"`
+	RecordingPostPadding time.Duration `envconfig:"RECORDING_POST_PADDING" default:"5m"`
+	// AccountConnectionLimit concurrent streams allowed by the sstv account, enforced on sessions and
+	// recording conflicts when set, 0 for no limit
+	AccountConnectionLimit int `envconfig:"ACCOUNT_CONNECTION_LIMIT"`
+	// TimeshiftDepth enables start-over buffers of this length
+	TimeshiftDepth    time.Duration `envconfig:"TIMESHIFT_DEPTH"`
+	TimeshiftDir      string        `envconfig:"TIMESHIFT_DIR" default:"/tmp/sstv-timeshift"`
+	TimeshiftChannels []string      `envconfig:"TIMESHIFT_CHANNELS"`
+	// ProxyMode serves streams through shared upstream sessions instead of redirecting
+	ProxyMode     bool          `envconfig:"PROXY_MODE"`
+	ProxySegments int           `envconfig:"PROXY_SEGMENTS" default:"10"`
+	ProxyIdle     time.Duration `envconfig:"PROXY_IDLE" default:"30s"`
+	// ClientStreamLimit concurrent streams allowed per client, 0 for no limit
+	ClientStreamLimit int `envconfig:"CLIENT_STREAM_LIMIT"`
+	// SessionRedirectTTL how long a redirected stream counts as playing
+	SessionRedirectTTL time.Duration `envconfig:"SESSION_REDIRECT_TTL" default:"3h"`
+	// ProfilesFile json file with Profile logins for the Xtream Codes API
+	ProfilesFile string `envconfig:"PROFILES_FILE"`
+	// SSDP announces the server as an HDHomeRun tuner on the local network
+	SSDP       bool   `envconfig:"SSDP"`
+	DeviceName string `envconfig:"DEVICE_NAME" default:"sstv-go"`
+	// HealthCheckInterval enables probing channel streams this often
+	HealthCheckInterval  time.Duration `envconfig:"HEALTH_CHECK_INTERVAL"`
+	HealthCheckHistory   int           `envconfig:"HEALTH_CHECK_HISTORY" default:"20"`
+	HealthCheckProviders []string      `envconfig:"HEALTH_CHECK_PROVIDERS" default:"ruv,static"`
+	// HealthPolicy what the playlist does with unhealthy channels: none, label, reorder or hide
+	HealthPolicy string `envconfig:"HEALTH_POLICY" default:"label"`
+	// EPGGrabInterval grabs schedules of the Icelandic channels this often, 0 turns the grabbers off
+	EPGGrabInterval time.Duration `envconfig:"EPG_GRAB_INTERVAL"`
+	// EPGGrabURL schedule json url, %s is replaced by the schedule name, required by the grabbers
+	EPGGrabURL string `envconfig:"EPG_GRAB_URL"`
+	// EgressProxiesFile json object of provider or provider/channel to an http, https or socks5 proxy url
+	EgressProxiesFile string `envconfig:"EGRESS_PROXIES_FILE"`
 }
 
 var cfg Config
@@ -31,6 +111,43 @@ func GetConfig() Config {
 		if err != nil {
 			log.Fatalf("Error initializing config: %s", err.Error())
 		}
+		if err := cfg.validate(); err != nil {
+			log.Fatalf("Error initializing config: %s", err.Error())
+		}
 	})
 	return cfg
 }
+
+// validate Check values envconfig can not, so a bad setting stops startup instead of breaking requests
+func (c Config) validate() error {
+	if _, err := parseDynamicNameTemplate(c.DynamicNameTemplate); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(c.EPGTimeZone); err != nil {
+		return fmt.Errorf("invalid EPG_TIME_ZONE %s: %s", c.EPGTimeZone, err)
+	}
+	for source, value := range c.EPGTimeCorrections {
+		if _, err := parseTimeCorrection(value); err != nil {
+			return fmt.Errorf("invalid EPG_TIME_CORRECTIONS for %s: %s", source, err)
+		}
+	}
+	switch c.EPGOverlapPolicy {
+	case OverlapPolicyNone, OverlapPolicyTrim, OverlapPolicyMerge:
+	default:
+		return fmt.Errorf("invalid EPG_OVERLAP_POLICY %q, must be none, trim or merge", c.EPGOverlapPolicy)
+	}
+	switch c.HealthPolicy {
+	case HealthPolicyNone, HealthPolicyLabel, HealthPolicyReorder, HealthPolicyHide:
+	default:
+		return fmt.Errorf("invalid HEALTH_POLICY %q, must be none, label, reorder or hide", c.HealthPolicy)
+	}
+	if c.EPGGrabInterval > 0 && !strings.Contains(c.EPGGrabURL, "%s") {
+		return fmt.Errorf("EPG_GRAB_URL must be set, with %%s for the schedule name, when EPG_GRAB_INTERVAL is")
+	}
+	switch c.DynamicNameEmpty {
+	case "keep", "mark", "hide":
+	default:
+		return fmt.Errorf("invalid DYNAMIC_NAME_EMPTY %q, must be keep, mark or hide", c.DynamicNameEmpty)
+	}
+	return nil
+}
@@ -0,0 +1,545 @@
+package sstv
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Recording statuses
+const (
+	RecordingScheduled = "scheduled"
+	RecordingActive    = "recording"
+	RecordingCompleted = "completed"
+	RecordingFailed    = "failed"
+	RecordingCancelled = "cancelled"
+)
+
+const recordingsStateFile = "recordings.json"
+
+// ErrUnknownRecording returned for recording ids that do not exist
+var ErrUnknownRecording = errors.New("unknown recording")
+
+// Recording A scheduled, active or finished recording of a channel
+type Recording struct {
+	ID          string    `json:"id"`
+	Rule        string    `json:"rule,omitempty"`
+	Channel     string    `json:"channel"`
+	Provider    string    `json:"provider"`
+	Key         string    `json:"key"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	Start       time.Time `json:"start"`
+	Stop        time.Time `json:"stop"`
+	RecordFrom  time.Time `json:"recordFrom"`
+	RecordUntil time.Time `json:"recordUntil"`
+	Status      string    `json:"status"`
+	File        string    `json:"file,omitempty"`
+	Segments    int       `json:"segments"`
+	Bytes       int64     `json:"bytes"`
+	Error       string    `json:"error,omitempty"`
+	// Conflict is set when more sstv recordings overlap than the account allows
+	Conflict bool      `json:"conflict"`
+	Created  time.Time `json:"created"`
+}
+
+// Done Whether the recording will not record anything more
+func (r Recording) Done() bool {
+	return r.Status == RecordingCompleted || r.Status == RecordingFailed || r.Status == RecordingCancelled
+}
+
+// Recorder Schedules recordings and captures their streams to disk
+type Recorder struct {
+	runtime    *RuntimeUtils
+	dir        string
+	interval   time.Duration
+	mu         sync.Mutex
+	recordings map[string]*Recording
+	rules      map[string]*RecordingRule
+	active     map[string]context.CancelFunc
+}
+
+// NewRecorder Recorder storing recordings and its state in dir
+func NewRecorder(runtime *RuntimeUtils, dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	rec := &Recorder{
+		runtime:    runtime,
+		dir:        dir,
+		interval:   5 * time.Second,
+		recordings: make(map[string]*Recording),
+		rules:      make(map[string]*RecordingRule),
+		active:     make(map[string]context.CancelFunc),
+	}
+	if err := rec.load(); err != nil {
+		return nil, err
+	}
+	if err := rec.loadRules(); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// load Read persisted recordings, rescheduling those interrupted by a restart
+func (rec *Recorder) load() error {
+	data, err := ioutil.ReadFile(filepath.Join(rec.dir, recordingsStateFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var recordings []*Recording
+	if err := json.Unmarshal(data, &recordings); err != nil {
+		return fmt.Errorf("could not parse %s: %s", recordingsStateFile, err)
+	}
+	for _, r := range recordings {
+		if r.Status == RecordingActive {
+			r.Status = RecordingScheduled
+		}
+		rec.recordings[r.ID] = r
+	}
+	log.Printf("Loaded %d recordings", len(recordings))
+	return nil
+}
+
+// save Persist all recordings, callers must hold rec.mu
+func (rec *Recorder) save() {
+	data, err := json.MarshalIndent(rec.list(), "", "  ")
+	if err != nil {
+		log.Printf("Could not marshal recordings: %s", err)
+		return
+	}
+	path := filepath.Join(rec.dir, recordingsStateFile)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		log.Printf("Could not save recordings: %s", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("Could not save recordings: %s", err)
+	}
+}
+
+// list Recordings sorted by start, callers must hold rec.mu
+func (rec *Recorder) list() []Recording {
+	result := []Recording{}
+	for _, r := range rec.recordings {
+		result = append(result, *r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].RecordFrom.Equal(result[j].RecordFrom) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].RecordFrom.Before(result[j].RecordFrom)
+	})
+	return result
+}
+
+// List All recordings sorted by start
+func (rec *Recorder) List() []Recording {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.list()
+}
+
+// activeOn Number of recordings in progress using an upstream account
+func (rec *Recorder) activeOn(account string) int {
+	count := 0
+	for _, r := range rec.List() {
+		if r.Status != RecordingActive {
+			continue
+		}
+		if provider, ok := GetProvider(r.Provider); ok && providerAccount(provider) == account {
+			count++
+		}
+	}
+	return count
+}
+
+// Get A recording by id
+func (rec *Recorder) Get(id string) (Recording, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	r, ok := rec.recordings[id]
+	if !ok {
+		return Recording{}, false
+	}
+	return *r, true
+}
+
+func newRecordingID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Schedule Add a recording, filling in its id, window and status
+func (rec *Recorder) Schedule(r Recording, prePadding time.Duration, postPadding time.Duration) (Recording, error) {
+	if len(r.Provider) == 0 || len(r.Key) == 0 {
+		return r, errors.New("recording has no channel")
+	}
+	if !r.Stop.After(r.Start) {
+		return r, errors.New("stop must be after start")
+	}
+	r.ID = newRecordingID()
+	r.RecordFrom = r.Start.Add(-prePadding)
+	r.RecordUntil = r.Stop.Add(postPadding)
+	if !r.RecordUntil.After(time.Now()) {
+		return r, errors.New("recording ends in the past")
+	}
+	r.Status = RecordingScheduled
+	r.Created = time.Now()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.recordings[r.ID] = &r
+	rec.flagConflicts()
+	rec.save()
+	log.Printf("Scheduled recording %s of %s on %s", r.ID, r.Title, r.Channel)
+	return r, nil
+}
+
+// Cancel Stop a scheduled or active recording
+func (rec *Recorder) Cancel(id string) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	r, ok := rec.recordings[id]
+	if !ok {
+		return ErrUnknownRecording
+	}
+	if r.Done() {
+		return fmt.Errorf("recording is already %s", r.Status)
+	}
+	if cancel, ok := rec.active[id]; ok {
+		cancel()
+	}
+	r.Status = RecordingCancelled
+	rec.flagConflicts()
+	rec.save()
+	return nil
+}
+
+// Delete Remove a recording and its file, cancelling it first if needed
+func (rec *Recorder) Delete(id string) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	r, ok := rec.recordings[id]
+	if !ok {
+		return ErrUnknownRecording
+	}
+	if cancel, ok := rec.active[id]; ok {
+		cancel()
+	}
+	if err := rec.removeFiles(*r); err != nil {
+		return err
+	}
+	delete(rec.recordings, id)
+	rec.flagConflicts()
+	rec.save()
+	return nil
+}
+
+// removeFiles Remove the stream and segment index of a recording
+func (rec *Recorder) removeFiles(r Recording) error {
+	if len(r.File) == 0 {
+		return nil
+	}
+	for _, name := range []string{r.File, segmentIndexFile(r.File)} {
+		if err := os.Remove(filepath.Join(rec.dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run Start due recordings and fail missed ones, forever
+func (rec *Recorder) Run() {
+	log.Printf("Recorder started in %s", rec.dir)
+	for {
+		rec.tick(time.Now())
+		time.Sleep(rec.interval)
+	}
+}
+
+// tick Start recordings due at now
+func (rec *Recorder) tick(now time.Time) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for id, r := range rec.recordings {
+		if r.Status != RecordingScheduled || r.RecordFrom.After(now) {
+			continue
+		}
+		if !r.RecordUntil.After(now) {
+			if r.Segments > 0 {
+				r.Status = RecordingCompleted
+			} else {
+				r.Status = RecordingFailed
+				r.Error = "missed"
+			}
+			rec.save()
+			continue
+		}
+		ctx, cancel := context.WithDeadline(context.Background(), r.RecordUntil)
+		rec.active[id] = cancel
+		r.Status = RecordingActive
+		if len(r.File) == 0 {
+			r.File = fmt.Sprintf("%s.ts", id)
+		}
+		rec.save()
+		go rec.record(ctx, id)
+	}
+}
+
+// update Apply f to a recording and persist it
+func (rec *Recorder) update(id string, f func(r *Recording)) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if r, ok := rec.recordings[id]; ok {
+		f(r)
+		rec.save()
+	}
+}
+
+// record Capture a recording until ctx is done
+func (rec *Recorder) record(ctx context.Context, id string) {
+	r, _ := rec.Get(id)
+	log.Printf("Recording %s of %s on %s", id, r.Title, r.Channel)
+
+	err := rec.capture(ctx, r)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if cancel, ok := rec.active[id]; ok {
+		cancel()
+		delete(rec.active, id)
+	}
+	current, ok := rec.recordings[id]
+	if !ok || current.Status != RecordingActive {
+		return
+	}
+	if err != nil && current.Segments == 0 {
+		current.Status = RecordingFailed
+		current.Error = err.Error()
+	} else {
+		current.Status = RecordingCompleted
+		if err != nil {
+			current.Error = err.Error()
+		}
+	}
+	log.Printf("Recording %s %s", id, current.Status)
+	rec.enforceMaxRecordings()
+	rec.flagConflicts()
+	rec.save()
+}
+
+// capture Append the recording's live stream segments to its file until ctx is done
+func (rec *Recorder) capture(ctx context.Context, r Recording) error {
+	provider, ok := GetProvider(r.Provider)
+	if !ok {
+		return fmt.Errorf("no provider found for %s", r.Provider)
+	}
+	file, err := os.OpenFile(filepath.Join(rec.dir, r.File), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	index, err := os.OpenFile(filepath.Join(rec.dir, segmentIndexFile(r.File)), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+
+	resolve := func() (string, error) {
+		return provider.StreamURL(*rec.runtime, r.Key)
+	}
+	client := upstreamClient(*rec.runtime, provider, r.Key, hlsClient)
+	return followHLS(ctx, client, fmt.Sprintf("Recording %s", r.ID), resolve, func(segment HLSSegment) error {
+		offset, err := file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		n, err := fetchSegment(client, segment.URL, file)
+		if err != nil {
+			return err
+		}
+		err = writeSegmentIndex(index, VODSegment{
+			Offset:        offset,
+			Length:        n,
+			Duration:      segment.Duration,
+			Discontinuity: segment.Discontinuity,
+		})
+		if err != nil {
+			log.Printf("Recording %s: could not write segment index: %s", r.ID, err)
+		}
+		rec.update(r.ID, func(r *Recording) {
+			r.Segments++
+			r.Bytes += n
+		})
+		return nil
+	})
+}
+
+// recordingRequest Body of POST /api/recordings
+type recordingRequest struct {
+	Channel     string     `json:"channel"`
+	Start       time.Time  `json:"start"`
+	Stop        *time.Time `json:"stop"`
+	Title       string     `json:"title"`
+	PrePadding  string     `json:"prePadding"`
+	PostPadding string     `json:"postPadding"`
+}
+
+// parsePadding Parse a padding duration, using fallback when empty
+func parsePadding(value string, fallback time.Duration) (time.Duration, error) {
+	if len(value) == 0 {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err == nil && d < 0 {
+		err = errors.New("padding can not be negative")
+	}
+	return d, err
+}
+
+// newRecording Recording for a programme on the requested channel, or a manual window if stop is set
+func newRecording(runtime RuntimeUtils, req recordingRequest) (Recording, error) {
+	provider, channel, err := findProviderChannel(runtime, req.Channel)
+	if err != nil {
+		return Recording{}, err
+	}
+	r := Recording{
+		Channel:  channel.ID,
+		Provider: provider.Name(),
+		Key:      channel.Key,
+		Title:    req.Title,
+		Start:    req.Start,
+	}
+
+	guide := getAPIGuide(runtime, "")
+	for _, prog := range guide.Programmes[channel.ID] {
+		if !prog.Start.After(req.Start) && prog.Stop.After(req.Start) {
+			r.Start = prog.Start
+			r.Stop = prog.Stop
+			r.Description = prog.Description
+			r.Category = prog.Category
+			if len(r.Title) == 0 {
+				r.Title = prog.Title
+			}
+			break
+		}
+	}
+	if req.Stop != nil {
+		r.Start = req.Start
+		r.Stop = *req.Stop
+	}
+	if r.Stop.IsZero() {
+		return r, fmt.Errorf("no programme on %s at %s", channel.ID, req.Start.Format(time.RFC3339))
+	}
+	if len(r.Title) == 0 {
+		r.Title = channel.Name
+	}
+	return r, nil
+}
+
+// ServeAPIRecordings List (GET) or schedule (POST) recordings
+func ServeAPIRecordings(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if runtime.Recorder == nil {
+			writeJSONError(w, http.StatusNotFound, errors.New("recording is not enabled"))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			recordings := runtime.Recorder.List()
+			page, from, to, err := paginate(r, len(recordings))
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+			page.Items = recordings[from:to]
+			writeJSON(w, http.StatusOK, page)
+		case http.MethodPost:
+			var req recordingRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+			cfg := GetConfig()
+			pre, err := parsePadding(req.PrePadding, cfg.RecordingPrePadding)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid prePadding: %s", err))
+				return
+			}
+			post, err := parsePadding(req.PostPadding, cfg.RecordingPostPadding)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid postPadding: %s", err))
+				return
+			}
+			recording, err := newRecording(runtime, req)
+			if err == ErrUnknownChannel {
+				writeJSONError(w, http.StatusNotFound, fmt.Errorf("no channel found for %s", req.Channel))
+				return
+			}
+			if err == nil {
+				recording, err = runtime.Recorder.Schedule(recording, pre, post)
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, recording)
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	}
+}
+
+// ServeAPIRecording Show (GET) a recording, or cancel it (DELETE) and remove it once finished
+func ServeAPIRecording(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if runtime.Recorder == nil {
+			writeJSONError(w, http.StatusNotFound, errors.New("recording is not enabled"))
+			return
+		}
+		id := mux.Vars(r)["id"]
+		recording, ok := runtime.Recorder.Get(id)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("no recording found for %s", id))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, recording)
+		case http.MethodDelete:
+			var err error
+			if recording.Done() {
+				err = runtime.Recorder.Delete(id)
+			} else {
+				err = runtime.Recorder.Cancel(id)
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	}
+}
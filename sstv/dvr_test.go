@@ -0,0 +1,200 @@
+package sstv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gotest.tools/assert"
+)
+
+// newHLSTestServer Serves a master playlist at /master.m3u8 and a vod media playlist of n segments
+func newHLSTestServer(n int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/master.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=100\nlow.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=200,CODECS=\"a,b\"\nhigh.m3u8\n"))
+		case "/high.m3u8":
+			body := "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n"
+			for i := 0; i < n; i++ {
+				body += fmt.Sprintf("#EXTINF:1.0,\nseg%d.ts\n", i)
+			}
+			w.Write([]byte(body + "#EXT-X-ENDLIST\n"))
+		default:
+			w.Write([]byte(r.URL.Path))
+		}
+	}))
+}
+
+func TestRecorder(t *testing.T) {
+	ts := newHLSTestServer(3)
+	defer ts.Close()
+	defer withProviders(&staticProvider{channels: []StaticChannel{
+		{ProviderChannel{ID: "T", Key: "t", Name: "Test"}, []string{ts.URL + "/master.m3u8"}},
+	}})()
+
+	dir, err := ioutil.TempDir("", "recordings")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	rec, err := NewRecorder(&RuntimeUtils{}, dir)
+	assert.NilError(t, err)
+
+	now := time.Now()
+	scheduled, err := rec.Schedule(Recording{
+		Channel:  "T",
+		Provider: "static",
+		Key:      "t",
+		Title:    "Test show",
+		Start:    now,
+		Stop:     now.Add(time.Minute),
+	}, time.Minute, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, scheduled.Status, RecordingScheduled)
+	assert.Equal(t, scheduled.RecordFrom, now.Add(-time.Minute))
+
+	rec.tick(now)
+	for i := 0; i < 50; i++ {
+		if r, _ := rec.Get(scheduled.ID); r.Done() {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	recorded, _ := rec.Get(scheduled.ID)
+	assert.Equal(t, recorded.Status, RecordingCompleted)
+	assert.Equal(t, recorded.Segments, 3)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, recorded.File))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "/seg0.ts/seg1.ts/seg2.ts")
+
+	router := mux.NewRouter()
+	router.HandleFunc("/vod/{id:[0-9a-f]+}.m3u8", ServeVODPlaylist(RuntimeUtils{Recorder: rec}))
+	router.HandleFunc("/vod/{id:[0-9a-f]+}.ts", ServeVODFile(RuntimeUtils{Recorder: rec}))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/vod/"+recorded.ID+".m3u8", nil))
+	assert.Equal(t, w.Body.String(), "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n"+
+		"#EXTINF:1.000,\n#EXT-X-BYTERANGE:8@0\n"+recorded.ID+".ts\n"+
+		"#EXTINF:1.000,\n#EXT-X-BYTERANGE:8@8\n"+recorded.ID+".ts\n"+
+		"#EXTINF:1.000,\n#EXT-X-BYTERANGE:8@16\n"+recorded.ID+".ts\n"+
+		"#EXT-X-ENDLIST\n")
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/vod/"+recorded.ID+".ts", nil)
+	req.Header.Set("Range", "bytes=8-15")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusPartialContent)
+	assert.Equal(t, w.Body.String(), "/seg1.ts")
+
+	reloaded, err := NewRecorder(&RuntimeUtils{}, dir)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, reloaded.List()[0].ID, recorded.ID)
+	assert.Equal(t, reloaded.List()[0].Status, RecordingCompleted)
+
+	assert.NilError(t, reloaded.Delete(recorded.ID))
+	_, err = ioutil.ReadFile(filepath.Join(dir, recorded.File))
+	assert.Assert(t, err != nil)
+}
+
+func TestRecorderReschedulesInterruptedRecordings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	state := `[{"id":"abc","provider":"static","key":"t","status":"recording"}]`
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, recordingsStateFile), []byte(state), 0644))
+
+	rec, err := NewRecorder(&RuntimeUtils{}, dir)
+	assert.NilError(t, err)
+	r, ok := rec.Get("abc")
+	assert.Assert(t, ok)
+	assert.Equal(t, r.Status, RecordingScheduled)
+}
+
+func TestRecorderApplyRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	rec, err := NewRecorder(&RuntimeUtils{}, dir)
+	assert.NilError(t, err)
+
+	now := time.Now().Truncate(time.Minute)
+	event := func(name string, hours int) SSEpgEvent {
+		return SSEpgEvent{
+			Name:        name,
+			Description: name + " live",
+			Start:       now.Add(time.Duration(hours) * time.Hour),
+			Stop:        now.Add(time.Duration(hours+2) * time.Hour),
+		}
+	}
+	news := func(hours int) SSEpgEvent {
+		return SSEpgEvent{Name: "Formula 1 News", Start: now.Add(time.Duration(hours) * time.Hour), Stop: now.Add(time.Duration(hours)*time.Hour + 30*time.Minute)}
+	}
+	epg := SSEpg{Channels: []SSEpgChannel{
+		{Number: "01", Events: []SSEpgEvent{event("Formula 1 Race", 1), event("Formula 1 Race", -5)}},
+		{Number: "02", Events: []SSEpgEvent{event("Formula 1 Race", 10), event("NHL: Bruins vs Leafs", 1)}},
+		{Number: "03", Events: []SSEpgEvent{event("NHL: Jets vs Flames", 1)}},
+		{Number: "04", Events: []SSEpgEvent{news(5), news(29)}},
+	}}
+	_, err = rec.AddRule(RecordingRule{Keywords: []string{"formula 1"}})
+	assert.NilError(t, err)
+	_, err = rec.AddRule(RecordingRule{Keywords: []string{"nhl"}})
+	assert.NilError(t, err)
+	_, err = rec.AddRule(RecordingRule{Keywords: []string{"x"}, Weekdays: []string{"someday"}})
+	assert.Error(t, err, "invalid weekday someday")
+
+	defer withConfig(func(c *Config) { c.AccountConnectionLimit = 2 })()
+	rec.ApplyRules(RuntimeUtils{}, epg)
+	rec.ApplyRules(RuntimeUtils{}, epg)
+
+	recordings := rec.List()
+	// The past race is skipped and the re-air on 02 is deduped, daily news without a description is not
+	assert.Equal(t, len(recordings), 5)
+	for _, r := range recordings {
+		assert.Equal(t, r.Status, RecordingScheduled)
+		// Three recordings overlap with a limit of two connections
+		assert.Equal(t, r.Conflict, r.Title != "Formula 1 News", r.Title)
+	}
+}
+
+func TestRecorderApplyRulesKeepsCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	rec, err := NewRecorder(&RuntimeUtils{}, dir)
+	assert.NilError(t, err)
+
+	now := time.Now().Truncate(time.Minute)
+	epg := SSEpg{Channels: []SSEpgChannel{
+		{Number: "01", Events: []SSEpgEvent{{Name: "Formula 1 Race", Start: now.Add(time.Hour), Stop: now.Add(3 * time.Hour)}}},
+		{Number: "02", Events: []SSEpgEvent{{Name: "Formula 1 Qualifying", Start: now.Add(time.Hour), Stop: now.Add(2 * time.Hour)}}},
+	}}
+	_, err = rec.AddRule(RecordingRule{Keywords: []string{"formula 1"}})
+	assert.NilError(t, err)
+	rec.ApplyRules(RuntimeUtils{}, epg)
+	recordings := rec.List()
+	assert.Equal(t, len(recordings), 2)
+
+	for _, r := range recordings {
+		if r.Title == "Formula 1 Race" {
+			assert.NilError(t, rec.Cancel(r.ID))
+			continue
+		}
+		rec.mu.Lock()
+		rec.recordings[r.ID].Status = RecordingFailed
+		rec.mu.Unlock()
+	}
+
+	rec.ApplyRules(RuntimeUtils{}, epg)
+	// The cancelled race stays dismissed, the failed qualifying is scheduled again in place of the failure
+	got := map[string][]string{}
+	for _, r := range rec.List() {
+		got[r.Title] = append(got[r.Title], r.Status)
+	}
+	assert.DeepEqual(t, got["Formula 1 Race"], []string{RecordingCancelled})
+	assert.DeepEqual(t, got["Formula 1 Qualifying"], []string{RecordingScheduled})
+}
@@ -0,0 +1,83 @@
+package sstv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Egress Outbound proxies for upstream requests, keyed by provider name or provider/channel.
+// A nil Egress connects directly.
+type Egress struct {
+	proxies map[string]*url.URL
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+// NewEgress Egress from proxy urls, http, https or socks5, keyed by provider or provider/channel
+func NewEgress(proxies map[string]string) (*Egress, error) {
+	e := &Egress{proxies: make(map[string]*url.URL), clients: make(map[string]*http.Client)}
+	for key, proxy := range proxies {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy for %s: %s", key, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("proxy for %s must be http, https or socks5, got %q", key, u.Scheme)
+		}
+		if len(u.Host) == 0 {
+			return nil, fmt.Errorf("proxy for %s has no host", key)
+		}
+		e.proxies[key] = u
+	}
+	return e, nil
+}
+
+// LoadEgress Read proxies from a json object of provider or provider/channel to proxy url
+func LoadEgress(path string) (*Egress, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var proxies map[string]string
+	if err := json.Unmarshal(data, &proxies); err != nil {
+		return nil, err
+	}
+	return NewEgress(proxies)
+}
+
+// proxy Proxy of a channel, falling back to the one of its provider
+func (e *Egress) proxy(provider string, channel string) *url.URL {
+	if u, ok := e.proxies[fmt.Sprintf("%s/%s", provider, channel)]; ok {
+		return u
+	}
+	return e.proxies[provider]
+}
+
+// Client base, or a copy of it going through the proxy of the channel
+func (e *Egress) Client(provider string, channel string, base *http.Client) *http.Client {
+	if e == nil {
+		return base
+	}
+	proxy := e.proxy(provider, channel)
+	if proxy == nil {
+		return base
+	}
+	// Clients are shared so connections through a proxy are reused
+	key := fmt.Sprintf("%s|%s", proxy, base.Timeout)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if client, ok := e.clients[key]; ok {
+		return client
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxy)
+	client := &http.Client{Transport: transport, Timeout: base.Timeout}
+	e.clients[key] = client
+	return client
+}
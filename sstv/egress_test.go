@@ -0,0 +1,83 @@
+package sstv
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestEgress(t *testing.T) {
+	upstream := newHLSTestServer(2)
+	defer upstream.Close()
+	var mu sync.Mutex
+	proxied := 0
+	// A forward proxy passing plain http requests on
+	forward := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied++
+		mu.Unlock()
+		resp, err := http.Get(r.URL.String())
+		if err != nil {
+			w.WriteHeader(502)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer forward.Close()
+
+	var direct *Egress
+	assert.Equal(t, direct.Client("static", "a", hlsClient), hlsClient)
+
+	egress, err := NewEgress(map[string]string{"static/a": forward.URL, "ruv": "socks5://127.0.0.1:1080"})
+	assert.NilError(t, err)
+	assert.Equal(t, egress.Client("static", "b", hlsClient), hlsClient)
+	assert.Assert(t, egress.Client("ruv", "ruv2", hlsClient) != hlsClient)
+	client := egress.Client("static", "a", hlsClient)
+	assert.Equal(t, egress.Client("static", "a", hlsClient), client)
+
+	defer withProviders(&staticProvider{channels: []StaticChannel{
+		{ProviderChannel{ID: "A", Key: "a"}, []string{upstream.URL + "/gone.m3u8", upstream.URL + "/master.m3u8"}},
+	}})()
+	provider, _ := GetProvider("static")
+	url, err := provider.StreamURL(RuntimeUtils{Egress: egress}, "a")
+	assert.NilError(t, err)
+	assert.Equal(t, url, upstream.URL+"/master.m3u8")
+	playlist, _, err := fetchMediaPlaylist(client, url)
+	assert.NilError(t, err)
+	assert.Equal(t, len(playlist.Segments), 2)
+	mu.Lock()
+	// The check of the failing source, then the master and the media playlist
+	assert.Equal(t, proxied, 3)
+	mu.Unlock()
+
+	_, err = NewEgress(map[string]string{"ruv": "ftp://proxy"})
+	assert.ErrorContains(t, err, "http, https or socks5")
+	_, err = NewEgress(map[string]string{"ruv": "socks5://"})
+	assert.ErrorContains(t, err, "no host")
+}
+
+func TestEgressAuth(t *testing.T) {
+	connects := make(chan string, 1)
+	// A proxy refusing every tunnel, so the login never leaves the test
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			connects <- r.Host
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer proxy.Close()
+	egress, err := NewEgress(map[string]string{"sstv": proxy.URL})
+	assert.NilError(t, err)
+
+	c := make(chan string)
+	go getAuth(RuntimeUtils{Cache: &FakeCache{}, Egress: egress}, c)
+	_, ok := <-c
+	assert.Assert(t, !ok)
+	assert.Equal(t, <-connects, "auth.smoothstreams.tv:443")
+}
@@ -0,0 +1,245 @@
+package sstv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// epgGrabCacheMinutes How long grabbed schedules are kept in the cache, to survive restarts and failed grabs
+const epgGrabCacheMinutes = 24 * 60
+
+// scheduleTimeFormat Start times in the schedule json, Icelandic time which is UTC all year
+const scheduleTimeFormat = "2006-01-02 15:04:05"
+
+var epgGrabClient = &http.Client{Timeout: 20 * time.Second}
+
+// EPGGrabber A channel whose programmes are built from a public schedule
+type EPGGrabber struct {
+	// ChannelID tvg-id of the channel in the playlist
+	ChannelID string
+	Name      string
+	// Schedule name of the channel's schedule at the grabber url
+	Schedule string
+}
+
+var defaultEPGGrabbers = []EPGGrabber{
+	{ChannelID: "RÚV", Name: "RÚV", Schedule: "ruv"},
+	{ChannelID: "RÚV Íþróttir", Name: "RÚV Íþróttir", Schedule: "ruvithrottir"},
+	{ChannelID: "Stöð 2", Name: "Stöð 2", Schedule: "stod2"},
+	{ChannelID: "Stöð 2 Sport", Name: "Stöð 2 Sport", Schedule: "stod2sport"},
+}
+
+// scheduleEvent Event in a schedule json
+type scheduleEvent struct {
+	Title            string `json:"title"`
+	OriginalTitle    string `json:"originalTitle"`
+	Duration         string `json:"duration"`
+	Description      string `json:"description"`
+	ShortDescription string `json:"shortDescription"`
+	Live             bool   `json:"live"`
+	StartTime        string `json:"startTime"`
+}
+
+// scheduleResponse Schedule json of a channel
+type scheduleResponse struct {
+	Results []scheduleEvent `json:"results"`
+}
+
+// EPGGrabbers Periodically grabs the schedules of channels without a guide of their own
+type EPGGrabbers struct {
+	runtime  *RuntimeUtils
+	url      string
+	interval time.Duration
+	grabbers []EPGGrabber
+	mu       sync.RWMutex
+	grabbed  map[string][]Programme
+}
+
+// NewEPGGrabbers Grabbers fetching schedules from url, with %s replaced by the schedule name, every interval
+func NewEPGGrabbers(runtime *RuntimeUtils, url string, interval time.Duration) *EPGGrabbers {
+	return &EPGGrabbers{
+		runtime:  runtime,
+		url:      url,
+		interval: interval,
+		grabbers: defaultEPGGrabbers,
+		grabbed:  make(map[string][]Programme),
+	}
+}
+
+// Run Grab all schedules every interval, forever
+func (g *EPGGrabbers) Run() {
+	for {
+		g.GrabAll()
+		time.Sleep(g.interval)
+	}
+}
+
+// GrabAll Grab every schedule once, keeping the last good one of a schedule that fails
+func (g *EPGGrabbers) GrabAll() {
+	for _, grabber := range g.grabbers {
+		cacheKey := fmt.Sprintf("epgGrab_%s", grabber.Schedule)
+		programmes, err := g.grab(grabber)
+		if err != nil {
+			log.Printf("EPG grabber %s failed: %s", grabber.Schedule, err)
+			programmes = g.cached(cacheKey)
+			if programmes == nil {
+				continue
+			}
+		} else if g.runtime.Cache != nil {
+			if data, err := json.Marshal(programmes); err == nil {
+				cache(g.runtime.Cache, cacheKey, string(data), epgGrabCacheMinutes)
+			}
+		}
+		g.mu.Lock()
+		g.grabbed[grabber.ChannelID] = programmes
+		g.mu.Unlock()
+	}
+}
+
+// cached Programmes of a schedule from the cache, nil if there are none
+func (g *EPGGrabbers) cached(cacheKey string) []Programme {
+	if g.runtime.Cache == nil {
+		return nil
+	}
+	val, err := g.runtime.Cache.Get(cacheKey)
+	if err != nil || len(val) == 0 {
+		return nil
+	}
+	var programmes []Programme
+	if err := json.Unmarshal([]byte(val), &programmes); err != nil {
+		return nil
+	}
+	return programmes
+}
+
+// grab Fetch the schedule of a grabber and convert it to programmes
+func (g *EPGGrabbers) grab(grabber EPGGrabber) ([]Programme, error) {
+	resp, err := epgGrabClient.Get(fmt.Sprintf(g.url, grabber.Schedule))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("schedule returned status %d", resp.StatusCode)
+	}
+	var schedule scheduleResponse
+	if err := json.NewDecoder(resp.Body).Decode(&schedule); err != nil {
+		return nil, fmt.Errorf("invalid schedule: %s", err)
+	}
+	if len(schedule.Results) == 0 {
+		return nil, errors.New("schedule is empty")
+	}
+	return scheduleToProgrammes(grabber.ChannelID, schedule.Results, sourceTimeCorrection(TimeSourceGrabber), epgLocation()), nil
+}
+
+// scheduleToProgrammes Programmes of schedule events with corrected times written in zone.
+// An event without a duration ends when the next one starts
+func scheduleToProgrammes(chanID string, events []scheduleEvent, correction timeCorrection, zone *time.Location) []Programme {
+	var programmes []Programme
+	for i, event := range events {
+		start, err := time.Parse(scheduleTimeFormat, event.StartTime)
+		if err != nil {
+			log.Printf("EPG grabber %s: skipping %s, invalid start %q", chanID, event.Title, event.StartTime)
+			continue
+		}
+		stop := start
+		if duration, ok := parseScheduleDuration(event.Duration); ok {
+			stop = start.Add(duration)
+		} else if i+1 < len(events) {
+			if next, err := time.Parse(scheduleTimeFormat, events[i+1].StartTime); err == nil {
+				stop = next
+			}
+		}
+		if !stop.After(start) {
+			continue
+		}
+		prog := Programme{
+			Title:   TextLang{Text: event.Title, Lang: "is"},
+			Channel: chanID,
+			Start:   formatXMLTVTime(correction.apply(start), zone),
+			Stop:    formatXMLTVTime(correction.apply(stop), zone),
+		}
+		if len(event.OriginalTitle) > 0 && event.OriginalTitle != event.Title {
+			prog.SubTitle = &TextLang{Text: event.OriginalTitle}
+		}
+		description := event.Description
+		if len(description) == 0 {
+			description = event.ShortDescription
+		}
+		if len(description) > 0 {
+			prog.Desc = &TextLang{Text: description, Lang: "is"}
+		}
+		if event.Live {
+			prog.Category = &TextLang{Text: "Live", Lang: "en"}
+		}
+		programmes = append(programmes, prog)
+	}
+	return programmes
+}
+
+// parseScheduleDuration Duration given as h:mm or h:mm:ss
+func parseScheduleDuration(s string) (time.Duration, bool) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	var duration time.Duration
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		duration += time.Duration(n) * units[i]
+	}
+	return duration, duration > 0
+}
+
+// EPG Channels and programmes of all grabbed schedules
+func (g *EPGGrabbers) EPG() EPG {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var epg EPG
+	for _, grabber := range g.grabbers {
+		programmes, ok := g.grabbed[grabber.ChannelID]
+		if !ok {
+			continue
+		}
+		epg.Channel = append(epg.Channel, Channel{ID: grabber.ChannelID, DisplayName: TextLang{Text: grabber.Name}})
+		epg.Programme = append(epg.Programme, programmes...)
+	}
+	return epg
+}
+
+// appendGrabbedEpg Add grabbed schedules for channels the epg has no programmes for
+func appendGrabbedEpg(runtime RuntimeUtils, epg *EPG) {
+	if runtime.Grabbers == nil {
+		return
+	}
+	covered := make(map[string]bool)
+	for _, prog := range epg.Programme {
+		covered[prog.Channel] = true
+	}
+	listed := make(map[string]bool)
+	for _, channel := range epg.Channel {
+		listed[channel.ID] = true
+	}
+	grabbed := runtime.Grabbers.EPG()
+	for _, channel := range grabbed.Channel {
+		if !listed[channel.ID] {
+			epg.Channel = append(epg.Channel, channel)
+		}
+	}
+	for _, prog := range grabbed.Programme {
+		if !covered[prog.Channel] {
+			epg.Programme = append(epg.Programme, prog)
+		}
+	}
+}
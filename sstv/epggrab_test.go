@@ -0,0 +1,92 @@
+package sstv
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+const ruvScheduleFixture = `{"results": [
+	{"title": "Morgunfréttir", "originalTitle": "", "duration": "0:30", "description": "Fréttir dagsins.", "live": true, "startTime": "2019-03-01 07:00:00"},
+	{"title": "Kastljós", "originalTitle": "", "duration": "", "shortDescription": "Umræðuþáttur.", "startTime": "2019-03-01 07:30:00"},
+	{"title": "Bíómynd", "originalTitle": "The Movie", "duration": "01:45:00", "startTime": "2019-03-01 08:15:00"},
+	{"title": "Bilað", "duration": "0:10", "startTime": "bráðum"}
+]}`
+
+func TestEPGGrabbers(t *testing.T) {
+	fail := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tv/ruv" && !fail {
+			w.Write([]byte(ruvScheduleFixture))
+			return
+		}
+		w.WriteHeader(500)
+	}))
+	defer ts.Close()
+
+	cached := map[string]string{}
+	runtime := RuntimeUtils{Cache: &FakeCache{
+		GetFunc: func(key string) (string, error) { return cached[key], nil },
+		SetFunc: func(key string, value string, exp time.Duration) error {
+			cached[key] = value
+			return nil
+		},
+	}}
+	runtime.Grabbers = NewEPGGrabbers(&runtime, ts.URL+"/tv/%s", time.Hour)
+	runtime.Grabbers.GrabAll()
+
+	grabbed := runtime.Grabbers.EPG()
+	assert.Equal(t, len(grabbed.Channel), 1)
+	assert.Equal(t, grabbed.Channel[0].ID, "RÚV")
+	assert.DeepEqual(t, grabbed.Programme, []Programme{
+		{
+			Start: "20190301070000 +0000", Stop: "20190301073000 +0000", Channel: "RÚV",
+			Title:    TextLang{Text: "Morgunfréttir", Lang: "is"},
+			Desc:     &TextLang{Text: "Fréttir dagsins.", Lang: "is"},
+			Category: &TextLang{Text: "Live", Lang: "en"},
+		},
+		{
+			Start: "20190301073000 +0000", Stop: "20190301081500 +0000", Channel: "RÚV",
+			Title: TextLang{Text: "Kastljós", Lang: "is"},
+			Desc:  &TextLang{Text: "Umræðuþáttur.", Lang: "is"},
+		},
+		{
+			Start: "20190301081500 +0000", Stop: "20190301100000 +0000", Channel: "RÚV",
+			Title:    TextLang{Text: "Bíómynd", Lang: "is"},
+			SubTitle: &TextLang{Text: "The Movie"},
+		},
+	})
+	assert.Assert(t, len(cached["epgGrab_ruv"]) > 0)
+
+	// A failed grab keeps the cached schedule
+	fail = true
+	runtime.Grabbers = NewEPGGrabbers(&runtime, ts.URL+"/tv/%s", time.Hour)
+	runtime.Grabbers.GrabAll()
+	assert.Equal(t, len(runtime.Grabbers.EPG().Programme), 3)
+
+	// Grabbed schedules only fill in channels the epg has no programmes for
+	epg := EPG{
+		Channel:   []Channel{{ID: "RÚV", DisplayName: TextLang{Text: "RÚV"}}},
+		Programme: []Programme{{Channel: "Stöð 2", Title: TextLang{Text: "Fréttir"}}},
+	}
+	appendGrabbedEpg(runtime, &epg)
+	assert.Equal(t, len(epg.Channel), 1)
+	assert.Equal(t, len(epg.Programme), 4)
+	_, err := xml.Marshal(epg)
+	assert.NilError(t, err)
+}
+
+func TestConfigValidateEPGGrabURL(t *testing.T) {
+	valid := validConfig()
+	assert.NilError(t, valid.validate())
+
+	grabbing := valid
+	grabbing.EPGGrabInterval = 6 * time.Hour
+	assert.ErrorContains(t, grabbing.validate(), "EPG_GRAB_URL")
+	grabbing.EPGGrabURL = "http://schedules/%s"
+	assert.NilError(t, grabbing.validate())
+}
@@ -0,0 +1,128 @@
+package sstv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sourceCheckClient Client for the quick check of a source before it is used
+var sourceCheckClient = &http.Client{Timeout: 3 * time.Second}
+
+// sourceCooldowns When failed sources may be tried again
+var sourceCooldowns = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{until: make(map[string]time.Time)}
+
+func markSourceFailed(source string, now time.Time) {
+	sourceCooldowns.Lock()
+	defer sourceCooldowns.Unlock()
+	sourceCooldowns.until[source] = now.Add(GetConfig().SourceCooldown)
+}
+
+func sourceCoolingDown(source string, now time.Time) bool {
+	sourceCooldowns.Lock()
+	defer sourceCooldowns.Unlock()
+	until, ok := sourceCooldowns.until[source]
+	if ok && !now.Before(until) {
+		delete(sourceCooldowns.until, source)
+		return false
+	}
+	return ok
+}
+
+// resolveSource Stream url of a source, a url or provider:key
+func resolveSource(runtime RuntimeUtils, source string) (string, error) {
+	if strings.Contains(source, "://") {
+		return source, nil
+	}
+	parts := strings.SplitN(source, ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid source %s", source)
+	}
+	provider, ok := GetProvider(parts[0])
+	if !ok {
+		return "", fmt.Errorf("no provider found for source %s", source)
+	}
+	return provider.StreamURL(runtime, parts[1])
+}
+
+// checkSource Verify a stream url serves a playlist with variants or segments
+func checkSource(client *http.Client, streamURL string) error {
+	playlist, err := fetchPlaylist(client, streamURL)
+	if err != nil {
+		return err
+	}
+	if !playlist.IsMaster() && len(playlist.Segments) == 0 {
+		return errors.New("playlist has no segments")
+	}
+	return nil
+}
+
+// pickSource Stream url of the first source passing a quick check, skipping sources that failed recently.
+// The last candidate is used without a check, as there is nothing left to fail over to.
+// Sources are checked with client.
+func pickSource(runtime RuntimeUtils, client *http.Client, sources []string) (string, error) {
+	now := time.Now()
+	var candidates []string
+	for _, source := range sources {
+		if !sourceCoolingDown(source, now) {
+			candidates = append(candidates, source)
+		}
+	}
+	if len(candidates) == 0 {
+		// Everything failed recently, better to retry than to give up
+		candidates = sources
+	}
+
+	lastErr := errors.New("channel has no sources")
+	for i, source := range candidates {
+		streamURL, err := resolveSource(runtime, source)
+		if err == nil && i == len(candidates)-1 {
+			return streamURL, nil
+		}
+		if err == nil {
+			err = checkSource(client, streamURL)
+		}
+		if err == nil {
+			return streamURL, nil
+		}
+		log.Printf("Source %s failed, skipping it for %s: %s", source, GetConfig().SourceCooldown, err)
+		markSourceFailed(source, now)
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// LoadStaticChannels Read static channel definitions from a json file
+func LoadStaticChannels(path string) ([]StaticChannel, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var channels []StaticChannel
+	if err := json.Unmarshal(data, &channels); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	for _, channel := range channels {
+		if len(channel.Key) == 0 || len(channel.ID) == 0 {
+			return nil, fmt.Errorf("channel %s needs an ID and a Key", channel.Name)
+		}
+		if len(channel.Sources) == 0 {
+			return nil, fmt.Errorf("channel %s has no sources", channel.Key)
+		}
+		if keys[channel.Key] {
+			return nil, fmt.Errorf("duplicate channel %s", channel.Key)
+		}
+		keys[channel.Key] = true
+	}
+	return channels, nil
+}
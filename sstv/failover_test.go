@@ -0,0 +1,84 @@
+package sstv
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestStaticChannelFailover(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/good.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXTINF:1.0,\nseg0.ts\n"))
+		case "/empty.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:1\n"))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+	defer withProviders(
+		&fakeProvider{name: "fake", channels: []ProviderChannel{{ID: "F-1", Key: "1"}}},
+		NewStaticProvider([]StaticChannel{
+			{ProviderChannel{ID: "A", Key: "a"}, []string{ts.URL + "/dead.m3u8", ts.URL + "/empty.m3u8", ts.URL + "/good.m3u8", "fake:1"}},
+			{ProviderChannel{ID: "B", Key: "b"}, []string{ts.URL + "/gone.m3u8", "fake:1"}},
+			{ProviderChannel{ID: "C", Key: "c"}, []string{ts.URL + "/missing.m3u8"}},
+		}),
+	)()
+	provider, _ := GetProvider("static")
+
+	url, err := provider.StreamURL(RuntimeUtils{}, "a")
+	assert.NilError(t, err)
+	assert.Equal(t, url, ts.URL+"/good.m3u8")
+	// Failed sources are skipped during their cool-down
+	url, err = provider.StreamURL(RuntimeUtils{}, "a")
+	assert.NilError(t, err)
+	assert.Equal(t, url, ts.URL+"/good.m3u8")
+	mu.Lock()
+	assert.Equal(t, hits["/dead.m3u8"], 1)
+	assert.Equal(t, hits["/empty.m3u8"], 1)
+	assert.Equal(t, hits["/good.m3u8"], 2)
+	mu.Unlock()
+
+	// Sources can be channels of other providers
+	url, err = provider.StreamURL(RuntimeUtils{}, "b")
+	assert.NilError(t, err)
+	assert.Equal(t, url, "http://upstream/1.m3u8")
+
+	// A single source is used without a check
+	url, err = provider.StreamURL(RuntimeUtils{}, "c")
+	assert.NilError(t, err)
+	assert.Equal(t, url, ts.URL+"/missing.m3u8")
+	mu.Lock()
+	assert.Equal(t, hits["/missing.m3u8"], 0)
+	mu.Unlock()
+}
+
+func TestLoadStaticChannels(t *testing.T) {
+	f, err := ioutil.TempFile("", "channels")
+	assert.NilError(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString(`[{"ID": "Stöð 2", "Key": "stod2", "Name": "Stöð 2", "Sources": ["http://a/index.m3u8", "http://b/index.m3u8"]}]`)
+	f.Close()
+	channels, err := LoadStaticChannels(f.Name())
+	assert.NilError(t, err)
+	assert.DeepEqual(t, channels, []StaticChannel{
+		{ProviderChannel{ID: "Stöð 2", Key: "stod2", Name: "Stöð 2"}, []string{"http://a/index.m3u8", "http://b/index.m3u8"}},
+	})
+
+	ioutil.WriteFile(f.Name(), []byte(`[{"ID": "X", "Key": "x"}]`), 0644)
+	_, err = LoadStaticChannels(f.Name())
+	assert.ErrorContains(t, err, "no sources")
+}
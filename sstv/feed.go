@@ -0,0 +1,34 @@
+package sstv
+
+import (
+	"crypto/sha1"
+	"sync"
+)
+
+// FeedListener Called with every newly parsed sstv feed
+type FeedListener func(runtime RuntimeUtils, epg SSEpg)
+
+var feedListenersMu sync.Mutex
+var feedListeners []FeedListener
+var lastFeedHash [sha1.Size]byte
+
+// OnFeedRefresh Register a listener for new sstv feeds
+func OnFeedRefresh(listener FeedListener) {
+	feedListenersMu.Lock()
+	defer feedListenersMu.Unlock()
+	feedListeners = append(feedListeners, listener)
+}
+
+// notifyFeedRefresh Run all listeners in the background if the feed changed since last time
+func notifyFeedRefresh(runtime RuntimeUtils, feed string, epg SSEpg) {
+	hash := sha1.Sum([]byte(feed))
+	feedListenersMu.Lock()
+	defer feedListenersMu.Unlock()
+	if hash == lastFeedHash {
+		return
+	}
+	lastFeedHash = hash
+	for _, listener := range feedListeners {
+		go listener(runtime, epg)
+	}
+}
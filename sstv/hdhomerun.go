@@ -0,0 +1,158 @@
+package sstv
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// hdhomerunDevice Kind of the one device sstv-go announces itself as, part of its identity
+const hdhomerunDevice = "hdhomerun"
+
+// hdhomerunDefaultTuners Tuners announced when the account has no connection limit
+const hdhomerunDefaultTuners = 2
+
+// hdhomerunTuners Tuners announced to HDHomeRun clients, which need at least one
+func hdhomerunTuners() int {
+	if limit := GetConfig().AccountConnectionLimit; limit > 0 {
+		return limit
+	}
+	return hdhomerunDefaultTuners
+}
+
+// deviceIdentity Stable identifier of an announced device, derived from the host and port
+func deviceIdentity(kind string) [sha1.Size]byte {
+	hostname, _ := os.Hostname()
+	return sha1.Sum([]byte(fmt.Sprintf("sstv-go|%s|%s|%s", hostname, GetConfig().Port, kind)))
+}
+
+// deviceUUID UPnP uuid of an announced device
+func deviceUUID(kind string) string {
+	id := deviceIdentity(kind)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// hdhomerunDeviceID Eight hex digit HDHomeRun device id
+func hdhomerunDeviceID() string {
+	id := deviceIdentity(hdhomerunDevice)
+	return strings.ToUpper(fmt.Sprintf("%x", id[0:4]))
+}
+
+// upnpDevice UPnP device description
+type upnpDevice struct {
+	XMLName     xml.Name `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	SpecVersion struct {
+		Major int `xml:"major"`
+		Minor int `xml:"minor"`
+	} `xml:"specVersion"`
+	URLBase string `xml:"URLBase"`
+	Device  struct {
+		DeviceType      string `xml:"deviceType"`
+		FriendlyName    string `xml:"friendlyName"`
+		Manufacturer    string `xml:"manufacturer"`
+		ModelName       string `xml:"modelName"`
+		ModelNumber     string `xml:"modelNumber"`
+		SerialNumber    string `xml:"serialNumber,omitempty"`
+		UDN             string `xml:"UDN"`
+		PresentationURL string `xml:"presentationURL,omitempty"`
+	} `xml:"device"`
+}
+
+// describeDevice Description of the HDHomeRun tuner served from baseURL.
+// It is a basic device, so clients do not look for the services of a media server
+func describeDevice(baseURL string) upnpDevice {
+	var desc upnpDevice
+	desc.SpecVersion.Major = 1
+	desc.URLBase = baseURL
+	desc.Device.DeviceType = ssdpBasicDevice
+	desc.Device.UDN = "uuid:" + deviceUUID(hdhomerunDevice)
+	desc.Device.FriendlyName = GetConfig().DeviceName + " Tuner"
+	desc.Device.Manufacturer = "Silicondust"
+	desc.Device.ModelName = "HDTC-2US"
+	desc.Device.ModelNumber = "HDTC-2US"
+	desc.Device.SerialNumber = hdhomerunDeviceID()
+	desc.Device.PresentationURL = baseURL + "/lineup.json"
+	return desc
+}
+
+// ServeDeviceDescription Serve the UPnP description of the announced HDHomeRun tuner
+func ServeDeviceDescription(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err := xml.MarshalIndent(describeDevice(getBaseURL(r)), "", "    ")
+		if err != nil {
+			log.Printf("Could not marshal device description: %s", err)
+			w.WriteHeader(500)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(xml.Header))
+		w.Write(result)
+	}
+}
+
+// HDHomeRunLineupEntry A channel in lineup.json
+type HDHomeRunLineupEntry struct {
+	GuideNumber string
+	GuideName   string
+	URL         string
+}
+
+// ServeHDHomeRunDiscover Serve discover.json for HDHomeRun clients
+func ServeHDHomeRunDiscover(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		baseURL := getBaseURL(r)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"FriendlyName":    GetConfig().DeviceName,
+			"Manufacturer":    "Silicondust",
+			"ModelNumber":     "HDTC-2US",
+			"FirmwareName":    "hdhomeruntc_atsc",
+			"FirmwareVersion": "20150826",
+			"DeviceID":        hdhomerunDeviceID(),
+			"DeviceAuth":      "sstv-go",
+			"BaseURL":         baseURL,
+			"LineupURL":       baseURL + "/lineup.json",
+			"TunerCount":      hdhomerunTuners(),
+		})
+	}
+}
+
+// ServeHDHomeRunLineup Serve lineup.json, every channel as a continuous MPEG-TS stream
+func ServeHDHomeRunLineup(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		baseURL := getBaseURL(r)
+		lineup := []HDHomeRunLineupEntry{}
+		for _, p := range Providers() {
+			channels, err := playlistChannels(runtime, p)
+			if err != nil {
+				log.Printf("Provider %s: could not list channels: %s", p.Name(), err)
+				continue
+			}
+			for _, channel := range channels {
+				lineup = append(lineup, HDHomeRunLineupEntry{
+					GuideNumber: strconv.Itoa(len(lineup) + 1),
+					GuideName:   channel.Name,
+					URL:         fmt.Sprintf("%s/ts/%s/%s", baseURL, p.Name(), channel.Key),
+				})
+			}
+		}
+		writeJSON(w, http.StatusOK, lineup)
+	}
+}
+
+// ServeHDHomeRunLineupStatus Serve lineup_status.json, the lineup never needs a scan
+func ServeHDHomeRunLineupStatus(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ScanInProgress": 0,
+			"ScanPossible":   0,
+			"Source":         "Cable",
+			"SourceList":     []string{"Cable"},
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package sstv
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// HeaderProvider Optionally implemented by providers whose channels need extra upstream request headers
+type HeaderProvider interface {
+	ChannelHeaders(channel string) map[string]string
+}
+
+// sortedHeaderNames Header names in a stable order for playlists
+func sortedHeaderNames(headers map[string]string) []string {
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// playerHeaderHints m3uHeaderHints for a stream the player fetches from upstream itself,
+// empty in proxy mode where upstream requests are made here with the headers
+func playerHeaderHints(runtime RuntimeUtils, headers map[string]string) string {
+	if runtime.Proxy != nil {
+		return ""
+	}
+	return m3uHeaderHints(headers)
+}
+
+// m3uHeaderHints #EXTVLCOPT and #KODIPROP lines asking players to send headers upstream
+func m3uHeaderHints(headers map[string]string) string {
+	if len(headers) == 0 {
+		return ""
+	}
+	var hints strings.Builder
+	var kodi []string
+	for _, name := range sortedHeaderNames(headers) {
+		value := headers[name]
+		switch http.CanonicalHeaderKey(name) {
+		case "User-Agent":
+			fmt.Fprintf(&hints, "#EXTVLCOPT:http-user-agent=%s\n", value)
+		case "Referer":
+			fmt.Fprintf(&hints, "#EXTVLCOPT:http-referrer=%s\n", value)
+		}
+		kodi = append(kodi, fmt.Sprintf("%s=%s", name, url.QueryEscape(value)))
+	}
+	fmt.Fprintf(&hints, "#KODIPROP:inputstream.adaptive.stream_headers=%s\n", strings.Join(kodi, "&"))
+	return hints.String()
+}
+
+// headerTransport Sets headers on every request before passing it on
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// upstreamClient base as a client for a channel's upstream, through its egress proxy and with its headers
+func upstreamClient(runtime RuntimeUtils, provider Provider, channel string, base *http.Client) *http.Client {
+	client := runtime.Egress.Client(provider.Name(), channel, base)
+	headerProvider, ok := provider.(HeaderProvider)
+	if !ok {
+		return client
+	}
+	headers := headerProvider.ChannelHeaders(channel)
+	if len(headers) == 0 {
+		return client
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &http.Client{
+		Transport: headerTransport{base: transport, headers: headers},
+		Timeout:   client.Timeout,
+	}
+}
@@ -0,0 +1,82 @@
+package sstv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gotest.tools/assert"
+)
+
+func TestProxyModeSendsChannelHeaders(t *testing.T) {
+	upstream := newHLSTestServer(2)
+	defer upstream.Close()
+	// Only lets requests with the channel's headers through
+	guarded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Referer") != "http://site/" || r.UserAgent() != "Player/1.0" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.Redirect(w, r, upstream.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer guarded.Close()
+	defer withProviders(&staticProvider{channels: []StaticChannel{
+		{ProviderChannel{ID: "H", Key: "h", Headers: map[string]string{"Referer": "http://site/", "User-Agent": "Player/1.0"}},
+			[]string{guarded.URL + "/master.m3u8"}},
+		{ProviderChannel{ID: "N", Key: "n"}, []string{guarded.URL + "/master.m3u8"}},
+	}})()
+
+	runtime := RuntimeUtils{}
+	runtime.Proxy = NewStreamProxy(&runtime, 5, time.Minute)
+	router := mux.NewRouter()
+	router.HandleFunc("/proxy/{provider}/{chan}.m3u8", ServeProxyPlaylist(runtime))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "http://local/proxy/static/h.m3u8", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// Channels without headers are rejected by the same upstream
+	provider, _ := GetProvider("static")
+	_, err := fetchPlaylist(upstreamClient(runtime, provider, "n", hlsClient), guarded.URL+"/master.m3u8")
+	assert.ErrorContains(t, err, "received status 403")
+}
+
+func TestPlaylistHeaderHints(t *testing.T) {
+	defer withProviders(&staticProvider{channels: []StaticChannel{
+		{ProviderChannel{ID: "H", Key: "h", Name: "Headers", Headers: map[string]string{"User-Agent": "Player/1.0"}}, []string{"http://h/index.m3u8"}},
+	}})()
+	profile := Profile{Username: "user", Password: "pass"}
+	hint := "#EXTVLCOPT:http-user-agent=Player/1.0\n"
+	proxied := RuntimeUtils{}
+	proxied.Proxy = NewStreamProxy(&proxied, 5, time.Minute)
+
+	playlist := func(generate func(c chan string)) string {
+		c := make(chan string)
+		go generate(c)
+		var result string
+		for line := range c {
+			result += line
+		}
+		return result
+	}
+	tests := []struct {
+		name     string
+		generate func(c chan string)
+		hinted   bool
+	}{
+		{"TestRedirectPlaylist", func(c chan string) { getBasem3u(RuntimeUtils{}, c, "http://base") }, true},
+		{"TestProxyPlaylist", func(c chan string) { getBasem3u(proxied, c, "http://base") }, false},
+		{"TestXtreamM3u8", func(c chan string) { getXtreamm3u(RuntimeUtils{}, c, "http://base", profile, "m3u8") }, true},
+		{"TestXtreamTS", func(c chan string) { getXtreamm3u(RuntimeUtils{}, c, "http://base", profile, "ts") }, false},
+		{"TestXtreamProxy", func(c chan string) { getXtreamm3u(proxied, c, "http://base", profile, "m3u8") }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := playlist(tt.generate)
+			assert.Equal(t, strings.Contains(result, hint), tt.hinted, result)
+		})
+	}
+}
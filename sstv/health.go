@@ -0,0 +1,272 @@
+package sstv
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// What ServeChanList does with unhealthy channels
+const (
+	HealthPolicyNone    = "none"
+	HealthPolicyLabel   = "label"
+	HealthPolicyReorder = "reorder"
+	HealthPolicyHide    = "hide"
+)
+
+// healthFailureThreshold Consecutive failed checks before a channel counts as unhealthy
+const healthFailureThreshold = 2
+
+// healthCheckWorkers Channels probed at the same time
+const healthCheckWorkers = 4
+
+// HealthCheck The result of probing a channel once
+type HealthCheck struct {
+	Time     time.Time `json:"time"`
+	OK       bool      `json:"ok"`
+	Error    string    `json:"error,omitempty"`
+	Segments int       `json:"segments"`
+	Latency  int64     `json:"latencyMs"`
+}
+
+// ChannelHealth Current state and recent checks of a channel
+type ChannelHealth struct {
+	ID                  string        `json:"id"`
+	Provider            string        `json:"provider"`
+	Name                string        `json:"name"`
+	Healthy             bool          `json:"healthy"`
+	ConsecutiveFailures int           `json:"consecutiveFailures"`
+	LastCheck           time.Time     `json:"lastCheck"`
+	LastHealthy         *time.Time    `json:"lastHealthy,omitempty"`
+	History             []HealthCheck `json:"history"`
+}
+
+// HealthChecker Periodically probes the streams of every channel
+type HealthChecker struct {
+	runtime   *RuntimeUtils
+	interval  time.Duration
+	history   int
+	providers []string
+	mu        sync.Mutex
+	channels  map[string]*ChannelHealth
+	// probing Probes in progress per upstream account
+	probing map[string]int
+}
+
+// NewHealthChecker Checker probing channels of providers, all when empty, every interval and keeping history checks
+func NewHealthChecker(runtime *RuntimeUtils, interval time.Duration, history int, providers []string) *HealthChecker {
+	return &HealthChecker{
+		runtime:   runtime,
+		interval:  interval,
+		history:   history,
+		providers: providers,
+		channels:  make(map[string]*ChannelHealth),
+		probing:   make(map[string]int),
+	}
+}
+
+// Run Check all channels every interval, forever
+func (hc *HealthChecker) Run() {
+	for {
+		hc.CheckAll()
+		time.Sleep(hc.interval)
+	}
+}
+
+// CheckAll Probe every channel once
+func (hc *HealthChecker) CheckAll() {
+	type job struct {
+		provider Provider
+		channel  ProviderChannel
+	}
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < healthCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				account := providerAccount(j.provider)
+				hc.addProbing(account, 1)
+				check, ok := probeChannel(*hc.runtime, j.provider, j.channel)
+				hc.addProbing(account, -1)
+				if ok {
+					hc.record(j.provider, j.channel, check)
+				}
+			}
+		}()
+	}
+	for _, p := range Providers() {
+		if len(hc.providers) > 0 && !containsString(hc.providers, p.Name()) {
+			continue
+		}
+		channels, err := p.Channels(*hc.runtime)
+		if err != nil {
+			log.Printf("Health: provider %s: could not list channels: %s", p.Name(), err)
+			continue
+		}
+		for _, channel := range channels {
+			jobs <- job{p, channel}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// addProbing Count a probe starting or ending on an upstream account
+func (hc *HealthChecker) addProbing(account string, n int) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.probing[account] += n
+}
+
+// activeOn Number of probes in progress on an upstream account
+func (hc *HealthChecker) activeOn(account string) int {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.probing[account]
+}
+
+// probeChannel Fetch the playlist of a channel and verify it has segments, false if there is nothing to check
+func probeChannel(runtime RuntimeUtils, provider Provider, channel ProviderChannel) (HealthCheck, bool) {
+	started := time.Now()
+	check := HealthCheck{Time: started}
+	streamURL, err := provider.StreamURL(runtime, channel.Key)
+	if err == ErrNotAiring {
+		return check, false
+	}
+	if err == nil {
+		var playlist HLSPlaylist
+		playlist, _, err = fetchMediaPlaylist(upstreamClient(runtime, provider, channel.Key, hlsClient), streamURL)
+		check.Segments = len(playlist.Segments)
+		if err == nil && check.Segments == 0 {
+			err = errors.New("playlist has no segments")
+		}
+	}
+	check.Latency = int64(time.Since(started) / time.Millisecond)
+	check.OK = err == nil
+	if err != nil {
+		check.Error = err.Error()
+	}
+	return check, true
+}
+
+// record Store a check, keeping the latest history checks of the channel
+func (hc *HealthChecker) record(provider Provider, channel ProviderChannel, check HealthCheck) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	health, ok := hc.channels[channel.ID]
+	if !ok {
+		health = &ChannelHealth{ID: channel.ID, Provider: provider.Name()}
+		hc.channels[channel.ID] = health
+	}
+	health.Name = channel.Name
+	health.LastCheck = check.Time
+	if check.OK {
+		health.ConsecutiveFailures = 0
+		checked := check.Time
+		health.LastHealthy = &checked
+	} else {
+		health.ConsecutiveFailures++
+		if health.ConsecutiveFailures == healthFailureThreshold {
+			log.Printf("Health: %s (%s) is unhealthy: %s", channel.Name, channel.ID, check.Error)
+		}
+	}
+	health.Healthy = health.ConsecutiveFailures < healthFailureThreshold
+	health.History = append(health.History, check)
+	if len(health.History) > hc.history {
+		health.History = health.History[len(health.History)-hc.history:]
+	}
+}
+
+// Unhealthy Whether a channel failed its recent checks, unchecked channels are healthy
+func (hc *HealthChecker) Unhealthy(id string) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	health, ok := hc.channels[id]
+	return ok && !health.Healthy
+}
+
+// Status Health of a channel
+func (hc *HealthChecker) Status(id string) (ChannelHealth, bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	health, ok := hc.channels[id]
+	if !ok {
+		return ChannelHealth{}, false
+	}
+	result := *health
+	result.History = append([]HealthCheck{}, health.History...)
+	return result, true
+}
+
+// List Health of all checked channels, unhealthy first
+func (hc *HealthChecker) List() []ChannelHealth {
+	hc.mu.Lock()
+	var ids []string
+	for id := range hc.channels {
+		ids = append(ids, id)
+	}
+	hc.mu.Unlock()
+	result := []ChannelHealth{}
+	for _, id := range ids {
+		if health, ok := hc.Status(id); ok {
+			result = append(result, health)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Healthy != result[j].Healthy {
+			return !result[i].Healthy
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// healthPlaylistName Name of a channel in the playlist under a health policy, false if it is hidden
+func healthPlaylistName(runtime RuntimeUtils, channel ProviderChannel, policy string) (string, bool) {
+	if runtime.Health == nil || !runtime.Health.Unhealthy(channel.ID) {
+		return channel.Name, true
+	}
+	switch policy {
+	case HealthPolicyHide:
+		return "", false
+	case HealthPolicyLabel:
+		return fmt.Sprintf("%s (offline)", channel.Name), true
+	}
+	return channel.Name, true
+}
+
+// ServeAPIHealth List the health of all checked channels
+func ServeAPIHealth(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if runtime.Health == nil {
+			writeJSONError(w, http.StatusNotFound, errors.New("health checks are not enabled"))
+			return
+		}
+		writeJSON(w, http.StatusOK, runtime.Health.List())
+	}
+}
+
+// ServeAPIChannelHealth Health and check history of one channel
+func ServeAPIChannelHealth(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if runtime.Health == nil {
+			writeJSONError(w, http.StatusNotFound, errors.New("health checks are not enabled"))
+			return
+		}
+		id := mux.Vars(r)["id"]
+		health, ok := runtime.Health.Status(id)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("no health checks for %s", id))
+			return
+		}
+		writeJSON(w, http.StatusOK, health)
+	}
+}
@@ -0,0 +1,92 @@
+package sstv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"gotest.tools/assert"
+)
+
+func TestHealthChecker(t *testing.T) {
+	ts := newHLSTestServer(2)
+	defer ts.Close()
+	good := ProviderChannel{ID: "GOOD", Key: "good", Name: "Good"}
+	dead := ProviderChannel{ID: "DEAD", Key: "dead", Name: "Dead"}
+	defer withProviders(
+		&staticProvider{channels: []StaticChannel{
+			{good, []string{ts.URL + "/master.m3u8"}},
+			{dead, []string{ts.URL + "/gone.m3u8"}},
+		}},
+		&fakeProvider{name: "skipped", channels: []ProviderChannel{{ID: "SKIPPED", Key: "1"}}},
+	)()
+
+	runtime := RuntimeUtils{}
+	runtime.Health = NewHealthChecker(&runtime, 0, 3, []string{"static"})
+	runtime.Health.CheckAll()
+	// A single failure is not enough to be unhealthy
+	assert.Assert(t, !runtime.Health.Unhealthy("DEAD"))
+	runtime.Health.CheckAll()
+	assert.Assert(t, runtime.Health.Unhealthy("DEAD"))
+	assert.Assert(t, !runtime.Health.Unhealthy("GOOD"))
+	assert.Assert(t, !runtime.Health.Unhealthy("SKIPPED"))
+	runtime.Health.CheckAll()
+	runtime.Health.CheckAll()
+
+	health, ok := runtime.Health.Status("GOOD")
+	assert.Assert(t, ok)
+	assert.Equal(t, len(health.History), 3)
+	assert.Equal(t, health.History[0].Segments, 2)
+	_, ok = runtime.Health.Status("SKIPPED")
+	assert.Assert(t, !ok)
+
+	tests := []struct {
+		policy  string
+		channel ProviderChannel
+		name    string
+		listed  bool
+	}{
+		{HealthPolicyLabel, dead, "Dead (offline)", true},
+		{HealthPolicyLabel, good, "Good", true},
+		{HealthPolicyHide, dead, "", false},
+		{HealthPolicyReorder, dead, "Dead", true},
+		{HealthPolicyNone, dead, "Dead", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+tt.channel.ID, func(t *testing.T) {
+			name, listed := healthPlaylistName(runtime, tt.channel, tt.policy)
+			assert.Equal(t, name, tt.name)
+			assert.Equal(t, listed, tt.listed)
+		})
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/health", ServeAPIHealth(runtime))
+	router.HandleFunc("/api/health/{id}", ServeAPIChannelHealth(runtime))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/health", nil))
+	var list []ChannelHealth
+	assert.NilError(t, json.NewDecoder(w.Body).Decode(&list))
+	assert.Equal(t, len(list), 2)
+	assert.Equal(t, list[0].ID, "DEAD")
+	assert.Equal(t, list[0].ConsecutiveFailures, 4)
+	assert.Assert(t, list[0].LastHealthy == nil)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/health/NOPE", nil))
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
+
+func TestConfigValidateHealthPolicy(t *testing.T) {
+	valid := validConfig()
+	for _, policy := range []string{HealthPolicyNone, HealthPolicyLabel, HealthPolicyReorder, HealthPolicyHide} {
+		valid.HealthPolicy = policy
+		assert.NilError(t, valid.validate())
+	}
+
+	badPolicy := valid
+	badPolicy.HealthPolicy = "drop"
+	assert.ErrorContains(t, badPolicy.validate(), "invalid HEALTH_POLICY")
+}
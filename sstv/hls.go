@@ -0,0 +1,289 @@
+package sstv
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hlsClient Client for upstream playlists and segments
+var hlsClient = &http.Client{
+	Timeout: time.Duration(15 * time.Second),
+}
+
+// HLSVariant A stream variant in a master playlist
+type HLSVariant struct {
+	URL       string
+	Bandwidth int64
+}
+
+// HLSSegment A segment in a media playlist
+type HLSSegment struct {
+	URL           string
+	Duration      float64
+	Sequence      int64
+	Discontinuity bool
+	// ProgramDateTime from EXT-X-PROGRAM-DATE-TIME, zero if not set
+	ProgramDateTime time.Time
+}
+
+// HLSPlaylist A parsed master or media playlist
+type HLSPlaylist struct {
+	Variants       []HLSVariant
+	Segments       []HLSSegment
+	TargetDuration float64
+	MediaSequence  int64
+	Ended          bool
+}
+
+// IsMaster Whether the playlist lists variants rather than segments
+func (p HLSPlaylist) IsMaster() bool {
+	return len(p.Variants) > 0
+}
+
+// parseAttributes Parse an attribute list like BANDWIDTH=1,CODECS="a,b"
+func parseAttributes(s string) map[string]string {
+	result := make(map[string]string)
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.IndexByte(s, ','); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+		result[key] = value
+		s = strings.TrimPrefix(s, ",")
+	}
+	return result
+}
+
+// parseM3U8 Parse a playlist, resolving uris against base
+func parseM3U8(r io.Reader, base *url.URL) (HLSPlaylist, error) {
+	var result HLSPlaylist
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if !scanner.Scan() || !strings.HasPrefix(strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff")), "#EXTM3U") {
+		return result, errors.New("not a m3u8 playlist")
+	}
+
+	var duration float64
+	var discontinuity bool
+	var programDateTime time.Time
+	var variant *HLSVariant
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0:
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			bandwidth, _ := strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+			variant = &HLSVariant{Bandwidth: bandwidth}
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			result.TargetDuration, _ = strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64)
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			result.MediaSequence, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0]
+			duration, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+			programDateTime, _ = time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+		case line == "#EXT-X-DISCONTINUITY":
+			discontinuity = true
+		case line == "#EXT-X-ENDLIST":
+			result.Ended = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			ref, err := url.Parse(line)
+			if err != nil {
+				return result, fmt.Errorf("invalid uri %s: %s", line, err)
+			}
+			if base != nil {
+				ref = base.ResolveReference(ref)
+			}
+			if variant != nil {
+				variant.URL = ref.String()
+				result.Variants = append(result.Variants, *variant)
+				variant = nil
+				continue
+			}
+			result.Segments = append(result.Segments, HLSSegment{
+				URL:             ref.String(),
+				Duration:        duration,
+				Sequence:        result.MediaSequence + int64(len(result.Segments)),
+				Discontinuity:   discontinuity,
+				ProgramDateTime: programDateTime,
+			})
+			if !programDateTime.IsZero() {
+				programDateTime = programDateTime.Add(time.Duration(duration * float64(time.Second)))
+			}
+			duration = 0
+			discontinuity = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	if !result.IsMaster() && len(result.Segments) == 0 && !result.Ended {
+		return result, errors.New("playlist has no segments")
+	}
+	return result, nil
+}
+
+// fetchPlaylist Fetch and parse a playlist
+func fetchPlaylist(client *http.Client, playlistURL string) (HLSPlaylist, error) {
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return HLSPlaylist{}, err
+	}
+	resp, err := client.Get(playlistURL)
+	if err != nil {
+		return HLSPlaylist{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return HLSPlaylist{}, fmt.Errorf("received status %d for %s", resp.StatusCode, playlistURL)
+	}
+	// Redirects change what relative uris resolve against
+	if resp.Request != nil && resp.Request.URL != nil {
+		base = resp.Request.URL
+	}
+	return parseM3U8(resp.Body, base)
+}
+
+// fetchMediaPlaylist Fetch a media playlist, following a master playlist to its best variant.
+// Returns the playlist and the url it was fetched from
+func fetchMediaPlaylist(client *http.Client, playlistURL string) (HLSPlaylist, string, error) {
+	playlist, err := fetchPlaylist(client, playlistURL)
+	if err != nil || !playlist.IsMaster() {
+		return playlist, playlistURL, err
+	}
+	best := playlist.Variants[0]
+	for _, variant := range playlist.Variants {
+		if variant.Bandwidth > best.Bandwidth {
+			best = variant
+		}
+	}
+	playlist, err = fetchPlaylist(client, best.URL)
+	if err == nil && playlist.IsMaster() {
+		err = errors.New("nested master playlists are not supported")
+	}
+	return playlist, best.URL, err
+}
+
+// fetchSegment Copy a segment to w, returning the number of bytes written
+func fetchSegment(client *http.Client, segmentURL string, w io.Writer) (int64, error) {
+	resp, err := client.Get(segmentURL)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return 0, fmt.Errorf("received status %d for %s", resp.StatusCode, segmentURL)
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// reloadInterval How long to wait before reloading a live playlist
+func (p HLSPlaylist) reloadInterval() time.Duration {
+	interval := time.Duration(p.TargetDuration * float64(time.Second) / 2)
+	if interval < time.Second {
+		return time.Second
+	}
+	return interval
+}
+
+// followHLS Pass each new segment of a live stream to handle until ctx is done or the playlist ends.
+// The stream url is resolved again after repeated playlist failures, as it may carry expiring auth.
+// Segments after gaps, failures or reconnects are marked as discontinuities.
+// Playlists are fetched with client.
+// Returns nil when the stream ended or ctx reached its deadline, otherwise the last error
+func followHLS(ctx context.Context, client *http.Client, name string, resolve func() (string, error), handle func(segment HLSSegment) error) error {
+	var streamURL string
+	var lastErr error
+	lastSequence := int64(-1)
+	discontinuity := true
+	failures := 0
+	for {
+		if len(streamURL) == 0 {
+			resolved, err := resolve()
+			if err != nil {
+				lastErr = err
+				log.Printf("%s: could not resolve stream: %s", name, err)
+			}
+			streamURL = resolved
+		}
+		wait := time.Second
+		if len(streamURL) > 0 {
+			playlist, _, err := fetchMediaPlaylist(client, streamURL)
+			if err != nil {
+				lastErr = err
+				failures++
+				log.Printf("%s: could not fetch playlist: %s", name, err)
+				if failures%3 == 0 {
+					// A re-resolved stream may number its segments from a lower sequence
+					streamURL = ""
+					lastSequence = -1
+					discontinuity = true
+				}
+			} else {
+				failures = 0
+				for _, segment := range playlist.Segments {
+					if ctx.Err() != nil {
+						break
+					}
+					if segment.Sequence <= lastSequence {
+						continue
+					}
+					if lastSequence >= 0 && segment.Sequence != lastSequence+1 {
+						discontinuity = true
+					}
+					segment.Discontinuity = segment.Discontinuity || discontinuity
+					if err := handle(segment); err != nil {
+						lastErr = err
+						discontinuity = true
+						log.Printf("%s: could not handle segment: %s", name, err)
+						continue
+					}
+					discontinuity = false
+					lastSequence = segment.Sequence
+				}
+				if playlist.Ended {
+					return nil
+				}
+				wait = playlist.reloadInterval()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil
+			}
+			return lastErr
+		case <-time.After(wait):
+		}
+	}
+}
@@ -36,6 +36,7 @@ type Programme struct {
 	Title    TextLang  `xml:"title"`
 	SubTitle *TextLang `xml:"sub-title,omitempty"`
 	Desc     *TextLang `xml:"desc,omitempty"`
+	Category *TextLang `xml:"category,omitempty"`
 }
 
 // EPG Top-level xml epg
@@ -48,15 +49,6 @@ type EPG struct {
 	Programme         []Programme `xml:"programme"`
 }
 
-// JSONEvent JSON event in sstv epg
-type JSONEvent struct {
-	Name        string
-	Description string
-	Time        string
-	Runtime     string
-	Category    string
-}
-
 // SSEpgEvent An event for SSEpgChannel
 type SSEpgEvent struct {
 	Name        string
@@ -0,0 +1,107 @@
+package sstv
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"text/template"
+	"time"
+)
+
+// DynamicNameData Values available to DynamicNameTemplate
+type DynamicNameData struct {
+	Number   string
+	Channel  string
+	Event    string
+	Category string
+	Start    string
+	Stop     string
+	Live     bool
+}
+
+// currentOrNextEvent The event airing at now, or the first starting before now+lookahead
+func currentOrNextEvent(channel SSEpgChannel, now time.Time, lookahead time.Duration) (SSEpgEvent, bool) {
+	var next *SSEpgEvent
+	for i, event := range channel.Events {
+		if !event.Stop.After(now) {
+			continue
+		}
+		if !event.Start.After(now) {
+			return event, true
+		}
+		if event.Start.Before(now.Add(lookahead)) && (next == nil || event.Start.Before(next.Start)) {
+			next = &channel.Events[i]
+		}
+	}
+	if next == nil {
+		return SSEpgEvent{}, false
+	}
+	return *next, true
+}
+
+// parseDynamicNameTemplate Parse a DynamicNameTemplate, trying it on sample data so unknown fields fail too
+func parseDynamicNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("name").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dynamic name template: %s", err)
+	}
+	sample := DynamicNameData{Number: "1", Channel: "Channel", Event: "Event", Category: "Category", Start: "18:00", Stop: "19:00", Live: true}
+	if err := tmpl.Execute(ioutil.Discard, sample); err != nil {
+		return nil, fmt.Errorf("invalid dynamic name template: %s", err)
+	}
+	return tmpl, nil
+}
+
+// dynamicChannels sstv channels named with cfg.DynamicNameTemplate after their current or next event.
+// Channels keep their feed names if the template does not parse
+func dynamicChannels(epg SSEpg, now time.Time, cfg Config) []ProviderChannel {
+	tmpl, err := parseDynamicNameTemplate(cfg.DynamicNameTemplate)
+	if err != nil {
+		log.Printf("Using feed channel names: %s", err)
+	}
+
+	var result []ProviderChannel
+	for _, channel := range epg.Channels {
+		pc := ProviderChannel{
+			ID:   ssChannelID(channel.Number),
+			Key:  channel.Number,
+			Name: channel.Name,
+			Logo: channel.Img,
+		}
+		event, ok := currentOrNextEvent(channel, now, cfg.DynamicNameLookahead)
+		if !ok {
+			switch cfg.DynamicNameEmpty {
+			case "hide":
+				continue
+			case "mark":
+				pc.Name = fmt.Sprintf("%s (no event)", channel.Name)
+			}
+			result = append(result, pc)
+			continue
+		}
+
+		if tmpl == nil {
+			result = append(result, pc)
+			continue
+		}
+
+		var name bytes.Buffer
+		err := tmpl.Execute(&name, DynamicNameData{
+			Number:   channel.Number,
+			Channel:  channel.Name,
+			Event:    event.Name,
+			Category: event.Category,
+			Start:    event.Start.In(epgLocation()).Format("15:04"),
+			Stop:     event.Stop.In(epgLocation()).Format("15:04"),
+			Live:     !event.Start.After(now),
+		})
+		if err != nil {
+			log.Printf("Could not render dynamic name for %s: %s", channel.Name, err)
+		} else {
+			pc.Name = name.String()
+		}
+		result = append(result, pc)
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package sstv
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestDynamicChannels(t *testing.T) {
+	now := time.Date(2019, 2, 12, 18, 0, 0, 0, time.UTC)
+	epg := SSEpg{Channels: []SSEpgChannel{
+		{Number: "1", Name: "SS 1", Events: []SSEpgEvent{
+			{Name: "Over", Start: now.Add(-3 * time.Hour), Stop: now.Add(-time.Hour)},
+			{Name: "Current", Start: now.Add(-time.Hour), Stop: now.Add(time.Hour)},
+		}},
+		{Number: "2", Name: "SS 2", Events: []SSEpgEvent{
+			{Name: "Later", Start: now.Add(2 * time.Hour), Stop: now.Add(3 * time.Hour)},
+			{Name: "Next", Start: now.Add(30 * time.Minute), Stop: now.Add(2 * time.Hour)},
+		}},
+		{Number: "3", Name: "SS 3", Events: []SSEpgEvent{
+			{Name: "Tomorrow", Start: now.Add(24 * time.Hour), Stop: now.Add(26 * time.Hour)},
+		}},
+	}}
+	template := "{{.Number}} · {{.Event}}{{if .Live}} (live){{else}} ({{.Start}}){{end}}"
+
+	tests := []struct {
+		name     string
+		template string
+		empty    string
+		names    []string
+	}{
+		{"TestKeep", template, "keep", []string{"1 · Current (live)", "2 · Next (18:30)", "SS 3"}},
+		{"TestMark", template, "mark", []string{"1 · Current (live)", "2 · Next (18:30)", "SS 3 (no event)"}},
+		{"TestHide", template, "hide", []string{"1 · Current (live)", "2 · Next (18:30)"}},
+		{"TestBadTemplate", "{{.Event", "keep", []string{"SS 1", "SS 2", "SS 3"}},
+		{"TestUnknownField", "{{.Missing}}", "keep", []string{"SS 1", "SS 2", "SS 3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{DynamicNameTemplate: tt.template, DynamicNameLookahead: 3 * time.Hour, DynamicNameEmpty: tt.empty}
+			var names []string
+			for _, channel := range dynamicChannels(epg, now, cfg) {
+				names = append(names, channel.Name)
+			}
+			assert.DeepEqual(t, names, tt.names)
+		})
+	}
+}
+
+func TestConfigValidateDynamicNames(t *testing.T) {
+	valid := validConfig()
+	assert.NilError(t, valid.validate())
+
+	badTemplate := valid
+	badTemplate.DynamicNameTemplate = "{{.Event"
+	assert.ErrorContains(t, badTemplate.validate(), "invalid dynamic name template")
+	badTemplate.DynamicNameTemplate = "{{.Missing}}"
+	assert.ErrorContains(t, badTemplate.validate(), "invalid dynamic name template")
+
+	badEmpty := valid
+	badEmpty.DynamicNameEmpty = "drop"
+	assert.ErrorContains(t, badEmpty.validate(), "DYNAMIC_NAME_EMPTY")
+}
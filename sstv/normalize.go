@@ -0,0 +1,204 @@
+package sstv
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// What normalization does with overlapping events on a channel
+const (
+	OverlapPolicyNone = "none"
+	// OverlapPolicyTrim ends the earlier event when the later one starts
+	OverlapPolicyTrim = "trim"
+	// OverlapPolicyMerge combines overlapping events into one
+	OverlapPolicyMerge = "merge"
+)
+
+// EPGNormalization How the events of a channel are cleaned up
+type EPGNormalization struct {
+	Overlap  string
+	FillGaps bool
+	GapTitle string
+	// MinGap shorter gaps are left empty
+	MinGap time.Duration
+	// PlaceholderLength longer gaps are filled with several placeholders, 0 for one per gap
+	PlaceholderLength time.Duration
+}
+
+// epgNormalization Normalization from the config
+func epgNormalization() EPGNormalization {
+	cfg := GetConfig()
+	return EPGNormalization{
+		Overlap:           cfg.EPGOverlapPolicy,
+		FillGaps:          cfg.EPGFillGaps,
+		GapTitle:          cfg.EPGGapTitle,
+		MinGap:            cfg.EPGMinGap,
+		PlaceholderLength: cfg.EPGPlaceholderLength,
+	}
+}
+
+// epgEvent An event of one channel being normalized, source is its index in the input or -1 for placeholders
+type epgEvent struct {
+	Start  time.Time
+	Stop   time.Time
+	Title  string
+	Desc   string
+	source int
+}
+
+// normalizeTimeline Sort events, drop empty ones and duplicates, resolve overlaps and fill gaps
+func normalizeTimeline(events []epgEvent, n EPGNormalization) []epgEvent {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Start.Equal(events[j].Start) {
+			return events[i].Stop.Before(events[j].Stop)
+		}
+		return events[i].Start.Before(events[j].Start)
+	})
+	var result []epgEvent
+	for _, event := range events {
+		if !event.Stop.After(event.Start) {
+			continue
+		}
+		if len(result) == 0 {
+			result = append(result, event)
+			continue
+		}
+		last := &result[len(result)-1]
+		if !event.Start.Before(last.Stop) {
+			result = append(result, event)
+			continue
+		}
+		if event.Title == last.Title && (event.Start.Equal(last.Start) || n.Overlap != OverlapPolicyNone) {
+			// The same event listed twice
+			if event.Stop.After(last.Stop) {
+				last.Stop = event.Stop
+			}
+			continue
+		}
+		switch n.Overlap {
+		case OverlapPolicyTrim:
+			if event.Start.Equal(last.Start) {
+				// Nothing left of the earlier event, the longer one wins
+				*last = event
+				continue
+			}
+			last.Stop = event.Start
+			result = append(result, event)
+		case OverlapPolicyMerge:
+			if event.Stop.After(last.Stop) {
+				last.Stop = event.Stop
+			}
+			if !containsString(strings.Split(last.Title, " / "), event.Title) {
+				last.Title = last.Title + " / " + event.Title
+			}
+			if len(event.Desc) > 0 && !strings.Contains(last.Desc, event.Desc) {
+				last.Desc = strings.TrimSpace(last.Desc + "\n" + event.Desc)
+			}
+		default:
+			result = append(result, event)
+		}
+	}
+	if !n.FillGaps || len(n.GapTitle) == 0 {
+		return result
+	}
+
+	var filled []epgEvent
+	for i, event := range result {
+		if i > 0 && event.Start.Sub(result[i-1].Stop) >= n.MinGap {
+			for start := result[i-1].Stop; start.Before(event.Start); {
+				stop := event.Start
+				if n.PlaceholderLength > 0 && stop.Sub(start) > n.PlaceholderLength {
+					stop = start.Add(n.PlaceholderLength)
+				}
+				filled = append(filled, epgEvent{Start: start, Stop: stop, Title: n.GapTitle, source: -1})
+				start = stop
+			}
+		}
+		filled = append(filled, event)
+	}
+	return filled
+}
+
+// normalizeSsEvents Normalized events of a sstv channel
+func normalizeSsEvents(events []SSEpgEvent, n EPGNormalization) []SSEpgEvent {
+	timeline := make([]epgEvent, len(events))
+	for i, event := range events {
+		timeline[i] = epgEvent{Start: event.Start, Stop: event.Stop, Title: event.Name, Desc: event.Description, source: i}
+	}
+	timeline = normalizeTimeline(timeline, n)
+	result := make([]SSEpgEvent, 0, len(timeline))
+	for _, e := range timeline {
+		event := SSEpgEvent{Name: e.Title}
+		if e.source >= 0 {
+			event = events[e.source]
+			event.Name = e.Title
+			event.Description = e.Desc
+		}
+		event.Start = e.Start
+		event.Stop = e.Stop
+		result = append(result, event)
+	}
+	return result
+}
+
+// normalizeSsEpg Normalize the events of every channel
+func normalizeSsEpg(epg SSEpg, n EPGNormalization) {
+	for i, channel := range epg.Channels {
+		epg.Channels[i].Events = normalizeSsEvents(channel.Events, n)
+	}
+}
+
+// normalizeProgrammes Normalized XMLTV programmes, grouped by channel with changed times written in zone.
+// Programmes with times that do not parse are kept as they are
+func normalizeProgrammes(programmes []Programme, n EPGNormalization, zone *time.Location) []Programme {
+	var channels []string
+	timelines := make(map[string][]epgEvent)
+	var result []Programme
+	for i, prog := range programmes {
+		start, err1 := parseXMLTVTime(prog.Start)
+		stop, err2 := parseXMLTVTime(prog.Stop)
+		if err1 != nil || err2 != nil {
+			result = append(result, prog)
+			continue
+		}
+		if _, ok := timelines[prog.Channel]; !ok {
+			channels = append(channels, prog.Channel)
+		}
+		var desc string
+		if prog.Desc != nil {
+			desc = prog.Desc.Text
+		}
+		timelines[prog.Channel] = append(timelines[prog.Channel], epgEvent{Start: start, Stop: stop, Title: prog.Title.Text, Desc: desc, source: i})
+	}
+
+	for _, channel := range channels {
+		for _, e := range normalizeTimeline(timelines[channel], n) {
+			if e.source < 0 {
+				result = append(result, Programme{
+					Channel: channel,
+					Start:   formatXMLTVTime(e.Start, zone),
+					Stop:    formatXMLTVTime(e.Stop, zone),
+					Title:   TextLang{Text: e.Title},
+				})
+				continue
+			}
+			prog := programmes[e.source]
+			start, _ := parseXMLTVTime(prog.Start)
+			if !start.Equal(e.Start) {
+				prog.Start = formatXMLTVTime(e.Start, zone)
+			}
+			stop, _ := parseXMLTVTime(prog.Stop)
+			if !stop.Equal(e.Stop) {
+				prog.Stop = formatXMLTVTime(e.Stop, zone)
+			}
+			prog.Title.Text = e.Title
+			if len(e.Desc) > 0 && (prog.Desc == nil || prog.Desc.Text != e.Desc) {
+				desc := TextLang{Text: e.Desc, Lang: prog.Title.Lang}
+				prog.Desc = &desc
+			}
+			result = append(result, prog)
+		}
+	}
+	return result
+}
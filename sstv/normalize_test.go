@@ -0,0 +1,84 @@
+package sstv
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestNormalizeSsEvents(t *testing.T) {
+	base := time.Date(2019, 2, 12, 18, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return base.Add(time.Duration(minutes) * time.Minute)
+	}
+	event := func(name string, start int, stop int) SSEpgEvent {
+		return SSEpgEvent{Name: name, Category: "Sports", Start: at(start), Stop: at(stop)}
+	}
+	events := []SSEpgEvent{
+		event("C", 240, 300),
+		event("A", 0, 90),
+		event("B", 60, 120),
+		event("B", 60, 120),
+		event("Empty", 130, 130),
+	}
+
+	tests := []struct {
+		name string
+		n    EPGNormalization
+		want []SSEpgEvent
+	}{
+		{"None", EPGNormalization{Overlap: OverlapPolicyNone}, []SSEpgEvent{
+			event("A", 0, 90), event("B", 60, 120), event("C", 240, 300),
+		}},
+		{"Trim", EPGNormalization{Overlap: OverlapPolicyTrim}, []SSEpgEvent{
+			event("A", 0, 60), event("B", 60, 120), event("C", 240, 300),
+		}},
+		{"Merge", EPGNormalization{Overlap: OverlapPolicyMerge}, []SSEpgEvent{
+			event("A / B", 0, 120), event("C", 240, 300),
+		}},
+		{"FillGaps", EPGNormalization{Overlap: OverlapPolicyTrim, FillGaps: true, GapTitle: "Nothing", MinGap: 5 * time.Minute, PlaceholderLength: time.Hour},
+			[]SSEpgEvent{
+				event("A", 0, 60), event("B", 60, 120),
+				{Name: "Nothing", Start: at(120), Stop: at(180)}, {Name: "Nothing", Start: at(180), Stop: at(240)},
+				event("C", 240, 300),
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := append([]SSEpgEvent{}, events...)
+			assert.DeepEqual(t, normalizeSsEvents(input, tt.n), tt.want)
+		})
+	}
+}
+
+func TestNormalizeProgrammes(t *testing.T) {
+	programmes := []Programme{
+		{Channel: "X", Start: "20190212190000 +0000", Stop: "20190212200000 +0000", Title: TextLang{Text: "Late", Lang: "en"}},
+		{Channel: "Y", Start: "20190212180000 +0000", Stop: "20190212183000 +0000", Title: TextLang{Text: "Y1"}},
+		{Channel: "X", Start: "20190212170000 +0000", Stop: "20190212190000 +0000", Title: TextLang{Text: "Early", Lang: "en"}},
+		{Channel: "X", Start: "20190212180000 +0000", Stop: "20190212183000 +0000", Title: TextLang{Text: "Inside", Lang: "en"}},
+		{Channel: "Z", Start: "bad", Stop: "bad", Title: TextLang{Text: "Unparsed"}},
+	}
+	n := EPGNormalization{Overlap: OverlapPolicyTrim, FillGaps: true, GapTitle: "No event scheduled", MinGap: 5 * time.Minute}
+	assert.DeepEqual(t, normalizeProgrammes(programmes, n, time.UTC), []Programme{
+		{Channel: "Z", Start: "bad", Stop: "bad", Title: TextLang{Text: "Unparsed"}},
+		{Channel: "X", Start: "20190212170000 +0000", Stop: "20190212180000 +0000", Title: TextLang{Text: "Early", Lang: "en"}},
+		{Channel: "X", Start: "20190212180000 +0000", Stop: "20190212183000 +0000", Title: TextLang{Text: "Inside", Lang: "en"}},
+		{Channel: "X", Start: "20190212183000 +0000", Stop: "20190212190000 +0000", Title: TextLang{Text: "No event scheduled"}},
+		{Channel: "X", Start: "20190212190000 +0000", Stop: "20190212200000 +0000", Title: TextLang{Text: "Late", Lang: "en"}},
+		{Channel: "Y", Start: "20190212180000 +0000", Stop: "20190212183000 +0000", Title: TextLang{Text: "Y1"}},
+	})
+}
+
+func TestConfigValidateOverlapPolicy(t *testing.T) {
+	valid := validConfig()
+	for _, policy := range []string{OverlapPolicyNone, OverlapPolicyTrim, OverlapPolicyMerge} {
+		valid.EPGOverlapPolicy = policy
+		assert.NilError(t, valid.validate())
+	}
+
+	badPolicy := valid
+	badPolicy.EPGOverlapPolicy = "drop"
+	assert.ErrorContains(t, badPolicy.validate(), "invalid EPG_OVERLAP_POLICY")
+}
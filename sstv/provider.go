@@ -0,0 +1,268 @@
+package sstv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrUnknownChannel returned by providers for channels they do not serve
+var ErrUnknownChannel = errors.New("unknown channel")
+
+// ProviderChannel A channel as listed by a Provider
+type ProviderChannel struct {
+	// ID is used as tvg-id in the playlist and channel id in the EPG
+	ID string
+	// Key identifies the channel within its provider, used in /p/{provider}/{chan}
+	Key  string
+	Name string
+	Logo string
+	// Headers sent with upstream requests, like User-Agent, Referer or Cookie
+	Headers map[string]string `json:",omitempty"`
+}
+
+// Provider A source of channels and streams
+type Provider interface {
+	// Name used to route /p/{provider}/{chan}
+	Name() string
+	// Channels lists all channels, in playlist order
+	Channels(runtime RuntimeUtils) ([]ProviderChannel, error)
+	// StreamURL resolves the upstream stream url for a channel key
+	StreamURL(runtime RuntimeUtils, channel string) (string, error)
+}
+
+// PlaylistProvider Optionally implemented by providers listing channels differently in the m3u
+type PlaylistProvider interface {
+	PlaylistChannels(runtime RuntimeUtils) ([]ProviderChannel, error)
+}
+
+// EPGProvider Optionally implemented by providers that supply their own EPG
+type EPGProvider interface {
+	EPG(runtime RuntimeUtils) (EPG, error)
+}
+
+var providersMu sync.RWMutex
+var providers = []Provider{
+	&ruvProvider{},
+	&staticProvider{channels: defaultStaticChannels},
+	&ssProvider{},
+}
+
+// RegisterProvider Add a provider to the playlist, replacing any with the same name
+func RegisterProvider(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	for i, existing := range providers {
+		if existing.Name() == p.Name() {
+			providers[i] = p
+			return
+		}
+	}
+	providers = append(providers, p)
+}
+
+// Providers All registered providers, in playlist order
+func Providers() []Provider {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	result := make([]Provider, len(providers))
+	copy(result, providers)
+	return result
+}
+
+// GetProvider Look up a registered provider by name
+func GetProvider(name string) (Provider, bool) {
+	for _, p := range Providers() {
+		if p.Name() == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// ChannelPather Optionally implemented by providers served on their own route
+type ChannelPather interface {
+	ChannelPath(channel string) string
+}
+
+// providerChannelURL Playable url for a channel on this server
+func providerChannelURL(baseURL string, provider Provider, channel ProviderChannel) string {
+	if pather, ok := provider.(ChannelPather); ok {
+		return baseURL + pather.ChannelPath(channel.Key)
+	}
+	return fmt.Sprintf("%s/p/%s/%s", baseURL, provider.Name(), channel.Key)
+}
+
+// ssProvider SmoothStreams channels, authenticated with getAuth
+type ssProvider struct{}
+
+func (p *ssProvider) Name() string {
+	return "sstv"
+}
+
+// Account streams use the configured SmoothStreams account
+func (p *ssProvider) Account() string {
+	return GetConfig().Username
+}
+
+// ChannelPath sstv channels keep their original /c/{chan} links
+func (p *ssProvider) ChannelPath(channel string) string {
+	return fmt.Sprintf("/c/%s", channel)
+}
+
+func (p *ssProvider) Channels(runtime RuntimeUtils) ([]ProviderChannel, error) {
+	epgChan := make(chan SSEpg)
+	go getSsEpg(runtime, epgChan)
+
+	var result []ProviderChannel
+	for _, channel := range (<-epgChan).Channels {
+		result = append(result, ProviderChannel{
+			ID:   ssChannelID(channel.Number),
+			Key:  channel.Number,
+			Name: channel.Name,
+			Logo: channel.Img,
+		})
+	}
+	return result, nil
+}
+
+// PlaylistChannels Channels named after their current or next event when DynamicNames is set
+func (p *ssProvider) PlaylistChannels(runtime RuntimeUtils) ([]ProviderChannel, error) {
+	cfg := GetConfig()
+	if !cfg.DynamicNames {
+		return p.Channels(runtime)
+	}
+	epgChan := make(chan SSEpg)
+	go getSsEpg(runtime, epgChan)
+	return dynamicChannels(<-epgChan, time.Now(), cfg), nil
+}
+
+func (p *ssProvider) StreamURL(runtime RuntimeUtils, channel string) (string, error) {
+	number, err := parseSsChannel(channel)
+	if err != nil {
+		return "", err
+	}
+	c := make(chan string)
+	go getAuth(runtime, c)
+	hash, ok := <-c
+	if !ok {
+		return "", errors.New("could not authenticate with smoothstreams")
+	}
+	return fmt.Sprintf("https://deu-uk1.SmoothStreams.tv/viewss/ch%02dq1.stream/playlist.m3u8?wmsAuthSign=%s", number, hash), nil
+}
+
+func (p *ssProvider) EPG(runtime RuntimeUtils) (EPG, error) {
+	epgChan := make(chan SSEpg)
+	go getSsEpg(runtime, epgChan)
+	return ssEpgToXMLTV(<-epgChan), nil
+}
+
+// ruvProvider RÚV channels, resolved with a ruvResolver
+type ruvProvider struct{}
+
+func (p *ruvProvider) Name() string {
+	return "ruv"
+}
+
+// ChannelPath ruv channels keep their original /ruv/{chan} links
+func (p *ruvProvider) ChannelPath(channel string) string {
+	return fmt.Sprintf("/ruv/%s", channel)
+}
+
+func (p *ruvProvider) Channels(runtime RuntimeUtils) ([]ProviderChannel, error) {
+	return []ProviderChannel{
+		{ID: "RÚV", Key: "ruv", Name: "RÚV", Logo: "http://iptv.irdn.is/images/ruv.png"},
+		{ID: "RÚV Íþróttir", Key: "ruv2", Name: "RÚV Íþróttir", Logo: "http://iptv.irdn.is/images/ruv2.png"},
+	}, nil
+}
+
+func (p *ruvProvider) StreamURL(runtime RuntimeUtils, channel string) (string, error) {
+	channels, _ := p.Channels(runtime)
+	for _, c := range channels {
+		if c.Key == channel {
+			resolver := newRuvResolver(GetConfig())
+			resolver.client = runtime.Egress.Client(p.Name(), channel, ruvClient)
+			return resolver.resolve(runtime, channel)
+		}
+	}
+	return "", ErrUnknownChannel
+}
+
+// StaticChannel A channel with fixed stream sources, tried in order.
+// A source is a stream url or another provider's channel as provider:key.
+type StaticChannel struct {
+	ProviderChannel
+	Sources []string
+}
+
+var defaultStaticChannels = []StaticChannel{
+	{ProviderChannel{ID: "N4", Key: "n4", Name: "N4", Logo: "http://iptv.irdn.is/images/n4.png"}, []string{"http://tv.vodafoneplay.is/n4/index.m3u8"}},
+	{ProviderChannel{ID: "Stöð 2", Key: "stod2", Name: "Stöð 2", Logo: "http://iptv.irdn.is/images/stod2.png"}, []string{
+		"http://visirlive.365cdn.is/hls-live/stod2.smil/playlist.m3u8",
+		"https://visirlive.365cdn.is/hls-live/stod2.smil/playlist.m3u8",
+	}},
+	{ProviderChannel{ID: "Stöð 2 Sport", Key: "stod2sport", Name: "Stöð 2 Sport", Logo: "http://iptv.irdn.is/images/stod2sport.png"}, []string{
+		"https://visirlive.365cdn.is/hls-live/straumur05.smil/playlist.m3u8",
+		"http://visirlive.365cdn.is/hls-live/straumur05.smil/playlist.m3u8",
+	}},
+	{ProviderChannel{ID: "Alþingi", Key: "althingi", Name: "Alþingi", Logo: "http://iptv.irdn.is/images/althingi.png"}, []string{"http://5-226-137-173.netvarp.is/althingi_600/index.m3u8"}},
+	{ProviderChannel{ID: "MBL", Key: "mbl", Name: "MBL", Logo: "http://mbl.is/img/hauslogo/mbl.generic.png"}, []string{"https://k100streymi.mbl.is/enski/index.m3u8"}},
+}
+
+// staticProvider Channels with fixed stream sources
+type staticProvider struct {
+	channels []StaticChannel
+}
+
+// NewStaticProvider Provider serving channels on /p/static/{chan}, replacing the default static channels
+func NewStaticProvider(channels []StaticChannel) Provider {
+	return &staticProvider{channels: channels}
+}
+
+func (p *staticProvider) Name() string {
+	return "static"
+}
+
+func (p *staticProvider) Channels(runtime RuntimeUtils) ([]ProviderChannel, error) {
+	var result []ProviderChannel
+	for _, channel := range p.channels {
+		result = append(result, channel.ProviderChannel)
+	}
+	return result, nil
+}
+
+// ChannelHeaders Headers from the channel definition
+func (p *staticProvider) ChannelHeaders(channel string) map[string]string {
+	for _, c := range p.channels {
+		if c.Key == channel {
+			return c.Headers
+		}
+	}
+	return nil
+}
+
+func (p *staticProvider) StreamURL(runtime RuntimeUtils, channel string) (string, error) {
+	for _, c := range p.channels {
+		if c.Key == channel {
+			return pickSource(runtime, upstreamClient(runtime, p, channel, sourceCheckClient), c.Sources)
+		}
+	}
+	return "", ErrUnknownChannel
+}
+
+// findProviderChannel Look up a channel by tvg-id across all providers
+func findProviderChannel(runtime RuntimeUtils, id string) (Provider, ProviderChannel, error) {
+	for _, p := range Providers() {
+		channels, err := p.Channels(runtime)
+		if err != nil {
+			continue
+		}
+		for _, channel := range channels {
+			if channel.ID == id {
+				return p, channel, nil
+			}
+		}
+	}
+	return nil, ProviderChannel{}, ErrUnknownChannel
+}
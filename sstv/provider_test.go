@@ -0,0 +1,125 @@
+package sstv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gotest.tools/assert"
+)
+
+func withProviders(p ...Provider) func() {
+	original := providers
+	providers = p
+	return func() {
+		providers = original
+	}
+}
+
+func validConfig() Config {
+	return Config{
+		DynamicNameTemplate: "{{.Event}}",
+		DynamicNameEmpty:    "keep",
+		EPGOverlapPolicy:    OverlapPolicyNone,
+		HealthPolicy:        HealthPolicyLabel,
+	}
+}
+
+func withConfig(change func(c *Config)) func() {
+	original := GetConfig()
+	change(&cfg)
+	return func() {
+		cfg = original
+	}
+}
+
+func TestGetBasem3uListsAllProviders(t *testing.T) {
+	defer withProviders(
+		&staticProvider{channels: defaultStaticChannels[:1]},
+		&staticProvider{channels: []StaticChannel{
+			{ProviderChannel{ID: "X", Key: "x", Name: "Ex", Logo: "logo"}, []string{"http://x/index.m3u8"}},
+			{ProviderChannel{ID: "H", Key: "h", Name: "Headers", Logo: "logo",
+				Headers: map[string]string{"User-Agent": "Player/1.0", "Referer": "http://site/", "Cookie": "a=b c"}}, []string{"http://h/index.m3u8"}},
+		}},
+	)()
+	c := make(chan string)
+	go getBasem3u(RuntimeUtils{}, c, "http://base")
+	var result string
+	for line := range c {
+		result += line
+	}
+	assert.Equal(t, result, "#EXTM3U x-tvg-url=\"http://base/g\"\n"+
+		"#EXTINF:-1 tvg-id=\"N4\" tvg-logo=\"http://iptv.irdn.is/images/n4.png\", N4\n"+
+		"http://base/p/static/n4\n"+
+		"#EXTINF:-1 tvg-id=\"X\" tvg-logo=\"logo\", Ex\n"+
+		"http://base/p/static/x\n"+
+		"#EXTINF:-1 tvg-id=\"H\" tvg-logo=\"logo\", Headers\n"+
+		"#EXTVLCOPT:http-referrer=http://site/\n"+
+		"#EXTVLCOPT:http-user-agent=Player/1.0\n"+
+		"#KODIPROP:inputstream.adaptive.stream_headers=Cookie=a%3Db+c&Referer=http%3A%2F%2Fsite%2F&User-Agent=Player%2F1.0\n"+
+		"http://base/p/static/h\n")
+}
+
+func TestServeProviderRedir(t *testing.T) {
+	defer withProviders(&staticProvider{channels: defaultStaticChannels})()
+	r := mux.NewRouter()
+	r.HandleFunc("/p/{provider}/{chan}", ServeProviderRedir(RuntimeUtils{}))
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		location string
+	}{
+		{"TestRedirect", "/p/static/n4", http.StatusFound, "http://tv.vodafoneplay.is/n4/index.m3u8"},
+		{"TestUnknownChannel", "/p/static/nope", http.StatusNotFound, ""},
+		{"TestUnknownProvider", "/p/nope/n4", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			assert.Equal(t, w.Code, tt.status)
+			assert.Equal(t, w.Header().Get("Location"), tt.location)
+		})
+	}
+}
+
+// fakeProvider Provider with fixed channels and EPG
+type fakeProvider struct {
+	name     string
+	channels []ProviderChannel
+	epg      EPG
+}
+
+func (p *fakeProvider) Name() string {
+	return p.name
+}
+
+func (p *fakeProvider) Channels(runtime RuntimeUtils) ([]ProviderChannel, error) {
+	return p.channels, nil
+}
+
+func (p *fakeProvider) StreamURL(runtime RuntimeUtils, channel string) (string, error) {
+	for _, c := range p.channels {
+		if c.Key == channel {
+			return "http://upstream/" + channel + ".m3u8", nil
+		}
+	}
+	return "", ErrUnknownChannel
+}
+
+func (p *fakeProvider) EPG(runtime RuntimeUtils) (EPG, error) {
+	return p.epg, nil
+}
+
+func fakeProgramme(channel string, title string, start time.Time, minutes int) Programme {
+	return Programme{
+		Channel: channel,
+		Title:   TextLang{Text: title},
+		Start:   start.UTC().Format("20060102150405 +0000"),
+		Stop:    start.Add(time.Duration(minutes) * time.Minute).UTC().Format("20060102150405 +0000"),
+	}
+}
@@ -0,0 +1,306 @@
+package sstv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sharedSegment A segment cached in memory for all viewers of a session
+type sharedSegment struct {
+	Sequence      int64
+	Duration      float64
+	Discontinuity bool
+	Data          []byte
+}
+
+// sharedSession One upstream stream shared by every local viewer of a channel
+type sharedSession struct {
+	name     string
+	cancel   context.CancelFunc
+	ready    chan struct{}
+	once     sync.Once
+	mu       sync.Mutex
+	segments []sharedSegment
+	sequence int64
+	viewers  map[string]time.Time
+	started  time.Time
+}
+
+// StreamProxy Shares upstream streams between local viewers
+type StreamProxy struct {
+	runtime  *RuntimeUtils
+	window   int
+	idle     time.Duration
+	mu       sync.Mutex
+	sessions map[string]*sharedSession
+	// sequences Next segment sequence of channels whose session closed, so playlists keep counting up
+	sequences map[string]int64
+}
+
+// NewStreamProxy Proxy caching window segments per channel, closing sessions idle for idle
+func NewStreamProxy(runtime *RuntimeUtils, window int, idle time.Duration) *StreamProxy {
+	return &StreamProxy{
+		runtime:   runtime,
+		window:    window,
+		idle:      idle,
+		sessions:  make(map[string]*sharedSession),
+		sequences: make(map[string]int64),
+	}
+}
+
+// viewerID Identify a viewer by address and user agent
+func viewerID(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return fmt.Sprintf("%s|%s", host, r.UserAgent())
+}
+
+// session The session of a channel, started if needed
+func (p *StreamProxy) session(provider Provider, key string) *sharedSession {
+	name := fmt.Sprintf("%s/%s", provider.Name(), key)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if session, ok := p.sessions[name]; ok {
+		return session
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &sharedSession{
+		name:    name,
+		cancel:  cancel,
+		ready:   make(chan struct{}),
+		viewers: make(map[string]time.Time),
+		started: time.Now(),
+	}
+	// The first segment of a session is marked as a discontinuity by followHLS
+	session.sequence = p.sequences[name]
+	p.sessions[name] = session
+	go p.fill(ctx, session, provider, key)
+	log.Printf("Proxy: opened upstream session for %s", name)
+	return session
+}
+
+// existing The session of a channel if it is running
+func (p *StreamProxy) existing(provider Provider, key string) (*sharedSession, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	session, ok := p.sessions[fmt.Sprintf("%s/%s", provider.Name(), key)]
+	return session, ok
+}
+
+// Run Close sessions without viewers, forever
+func (p *StreamProxy) Run() {
+	for {
+		time.Sleep(5 * time.Second)
+		p.expire(time.Now())
+	}
+}
+
+// expire Close sessions whose last viewer left before now-idle
+func (p *StreamProxy) expire(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, session := range p.sessions {
+		if session.watching(now, p.idle) == 0 && now.Sub(session.started) > p.idle {
+			log.Printf("Proxy: last viewer left %s, closing upstream session", name)
+			session.cancel()
+			p.remove(session)
+		}
+	}
+}
+
+// remove Forget a session, remembering where its sequence ended. Caller must hold the lock
+func (p *StreamProxy) remove(session *sharedSession) {
+	session.mu.Lock()
+	p.sequences[session.name] = session.sequence
+	session.mu.Unlock()
+	delete(p.sessions, session.name)
+}
+
+// fill Cache upstream segments until ctx is cancelled
+func (p *StreamProxy) fill(ctx context.Context, session *sharedSession, provider Provider, key string) {
+	resolve := func() (string, error) {
+		return provider.StreamURL(*p.runtime, key)
+	}
+	client := upstreamClient(*p.runtime, provider, key, hlsClient)
+	err := followHLS(ctx, client, fmt.Sprintf("Proxy %s", session.name), resolve, func(segment HLSSegment) error {
+		var data bytes.Buffer
+		if _, err := fetchSegment(client, segment.URL, &data); err != nil {
+			return err
+		}
+		session.add(segment, data.Bytes(), p.window)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Proxy %s: upstream stopped: %s", session.name, err)
+		// Let the next viewer open a fresh session
+		p.mu.Lock()
+		if p.sessions[session.name] == session {
+			p.remove(session)
+		}
+		p.mu.Unlock()
+	}
+	// Wake viewers waiting on a session that never started
+	session.once.Do(func() { close(session.ready) })
+}
+
+// add Cache a segment, keeping the latest window segments
+func (session *sharedSession) add(segment HLSSegment, data []byte, window int) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.segments = append(session.segments, sharedSegment{
+		Sequence:      session.sequence,
+		Duration:      segment.Duration,
+		Discontinuity: segment.Discontinuity,
+		Data:          data,
+	})
+	session.sequence++
+	if len(session.segments) > window {
+		session.segments = session.segments[len(session.segments)-window:]
+	}
+	session.once.Do(func() { close(session.ready) })
+}
+
+// touch Record activity of a viewer
+func (session *sharedSession) touch(viewer string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.viewers[viewer] = time.Now()
+}
+
+// watching Number of viewers active within idle before now, forgetting the others
+func (session *sharedSession) watching(now time.Time, idle time.Duration) int {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	for viewer, seen := range session.viewers {
+		if now.Sub(seen) > idle {
+			delete(session.viewers, viewer)
+		}
+	}
+	return len(session.viewers)
+}
+
+// snapshot The cached segments
+func (session *sharedSession) snapshot() []sharedSegment {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	result := make([]sharedSegment, len(session.segments))
+	copy(result, session.segments)
+	return result
+}
+
+// segment A cached segment by sequence
+func (session *sharedSession) segment(sequence int64) (sharedSegment, bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	for _, segment := range session.segments {
+		if segment.Sequence == sequence {
+			return segment, true
+		}
+	}
+	return sharedSegment{}, false
+}
+
+// proxyPlaylistURL Local playlist of a proxied channel
+func proxyPlaylistURL(baseURL string, provider Provider, key string) string {
+	return fmt.Sprintf("%s/proxy/%s/%s.m3u8", baseURL, provider.Name(), key)
+}
+
+// proxyChannel Provider and channel key of the request, writing a 404 if there is none
+func proxyChannel(runtime RuntimeUtils, w http.ResponseWriter, r *http.Request) (Provider, string, bool) {
+	vars := mux.Vars(r)
+	if runtime.Proxy != nil {
+		if provider, ok := GetProvider(vars["provider"]); ok {
+			return provider, vars["chan"], true
+		}
+	}
+	w.WriteHeader(404)
+	w.Write([]byte(fmt.Sprintf("No proxied channel found for %s/%s", vars["provider"], vars["chan"])))
+	return nil, "", false
+}
+
+// ServeProxyPlaylist Serve the live playlist of a shared session
+func ServeProxyPlaylist(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, key, ok := proxyChannel(runtime, w, r)
+		if !ok {
+			return
+		}
+		// Opening a session connects upstream, so the playlist is subject to the stream limits
+		if runtime.Sessions != nil {
+			if _, err := runtime.Sessions.Start(r, provider, key, SessionProxy); err != nil {
+				writeStreamLimit(w, key)
+				return
+			}
+		}
+		session := runtime.Proxy.session(provider, key)
+		session.touch(viewerID(r))
+		select {
+		case <-session.ready:
+		case <-time.After(8 * time.Second):
+			// Answer within the server write timeout
+		}
+		segments := session.snapshot()
+		if len(segments) == 0 {
+			w.WriteHeader(503)
+			w.Write([]byte("Upstream did not start, try again"))
+			return
+		}
+		target := 1.0
+		for _, segment := range segments {
+			target = math.Max(target, segment.Duration)
+		}
+		baseURL := getBaseURL(r)
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n",
+			int(math.Ceil(target)), segments[0].Sequence)
+		for _, segment := range segments {
+			// Viewers of a previous session switch at the first segment of the next one
+			if segment.Discontinuity && segment.Sequence > 0 {
+				fmt.Fprint(w, "#EXT-X-DISCONTINUITY\n")
+			}
+			fmt.Fprintf(w, "#EXTINF:%.3f,\n%s/proxy/%s/%s/%d.ts\n", segment.Duration, baseURL, provider.Name(), key, segment.Sequence)
+		}
+	}
+}
+
+// ServeProxySegment Serve a cached segment of a shared session
+func ServeProxySegment(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, key, ok := proxyChannel(runtime, w, r)
+		if !ok {
+			return
+		}
+		sequence, _ := strconv.ParseInt(mux.Vars(r)["seq"], 10, 64)
+		session, ok := runtime.Proxy.existing(provider, key)
+		var segment sharedSegment
+		if ok {
+			session.touch(viewerID(r))
+			if runtime.Sessions != nil {
+				runtime.Sessions.Touch(r, provider.Name(), key)
+			}
+			segment, ok = session.segment(sequence)
+		}
+		if !ok {
+			w.WriteHeader(404)
+			w.Write([]byte(fmt.Sprintf("Segment %d is not cached", sequence)))
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Header().Set("Content-Length", strconv.Itoa(len(segment.Data)))
+		w.Write(segment.Data)
+	}
+}
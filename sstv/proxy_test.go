@@ -0,0 +1,147 @@
+package sstv
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gotest.tools/assert"
+)
+
+func TestStreamProxySharesUpstream(t *testing.T) {
+	var mu sync.Mutex
+	fetched := map[string]int{}
+	upstream := newHLSTestServer(3)
+	defer upstream.Close()
+	counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched[r.URL.Path]++
+		mu.Unlock()
+		resp, err := http.Get(upstream.URL + r.URL.Path)
+		if err != nil {
+			w.WriteHeader(502)
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(w, resp.Body)
+	}))
+	defer counting.Close()
+	defer withProviders(&staticProvider{channels: []StaticChannel{
+		{ProviderChannel{ID: "T", Key: "t", Name: "Test"}, []string{counting.URL + "/master.m3u8"}},
+	}})()
+
+	runtime := RuntimeUtils{}
+	runtime.Proxy = NewStreamProxy(&runtime, 2, time.Minute)
+	router := mux.NewRouter()
+	router.HandleFunc("/p/{provider}/{chan}", ServeProviderRedir(runtime))
+	router.HandleFunc("/proxy/{provider}/{chan}.m3u8", ServeProxyPlaylist(runtime))
+	router.HandleFunc("/proxy/{provider}/{chan}/{seq:[0-9]+}.ts", ServeProxySegment(runtime))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "http://local/p/static/t", nil))
+	assert.Equal(t, w.Code, http.StatusFound)
+	assert.Equal(t, w.Header().Get("Location"), "http://local/proxy/static/t.m3u8")
+
+	for viewer := 0; viewer < 2; viewer++ {
+		r := httptest.NewRequest("GET", "http://local/proxy/static/t.m3u8", nil)
+		r.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", viewer)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, w.Code, http.StatusOK)
+	}
+	// Wait for the upstream playlist to be consumed
+	for i := 0; i < 50 && len(runtime.Proxy.sessions["static/t"].snapshot()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "http://local/proxy/static/t.m3u8", nil))
+	body := w.Body.String()
+	assert.Assert(t, strings.Contains(body, "#EXT-X-MEDIA-SEQUENCE:1\n"), body)
+	assert.Assert(t, strings.Contains(body, "http://local/proxy/static/t/2.ts"), body)
+	assert.Assert(t, !strings.Contains(body, "/0.ts"), body)
+
+	for viewer := 0; viewer < 2; viewer++ {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "http://local/proxy/static/t/2.ts", nil))
+		assert.Equal(t, w.Code, http.StatusOK)
+		data, _ := ioutil.ReadAll(w.Body)
+		assert.Equal(t, string(data), "/seg2.ts")
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "http://local/proxy/static/t/0.ts", nil))
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	mu.Lock()
+	assert.Equal(t, fetched["/seg2.ts"], 1)
+	assert.Equal(t, fetched["/master.m3u8"], 1)
+	mu.Unlock()
+
+	runtime.Proxy.expire(time.Now())
+	assert.Equal(t, len(runtime.Proxy.sessions), 1)
+	runtime.Proxy.expire(time.Now().Add(2 * time.Minute))
+	assert.Equal(t, len(runtime.Proxy.sessions), 0)
+}
+
+func TestStreamProxyLimits(t *testing.T) {
+	provider := &accountProvider{fakeProvider{name: "fake", channels: []ProviderChannel{{ID: "F-1", Key: "1"}, {ID: "F-2", Key: "2"}}}}
+	defer withProviders(provider)()
+	runtime := RuntimeUtils{}
+	runtime.Proxy = NewStreamProxy(&runtime, 2, time.Minute)
+	runtime.Sessions = NewSessionTracker(&runtime, 0, 1, time.Hour, time.Minute)
+	router := mux.NewRouter()
+	router.HandleFunc("/proxy/{provider}/{chan}.m3u8", ServeProxyPlaylist(runtime))
+	get := func(client string, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://local"+path, nil)
+		r.RemoteAddr = client + ":1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	ready := make(chan struct{})
+	close(ready)
+	runtime.Proxy.sessions["fake/1"] = &sharedSession{name: "fake/1", cancel: func() {}, ready: ready, viewers: map[string]time.Time{}, started: time.Now(),
+		segments: []sharedSegment{{Sequence: 5, Duration: 6}}, sequence: 6}
+	w := get("10.0.0.1", "/proxy/fake/1.m3u8")
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// Requesting the proxy playlist directly does not get around the account limit
+	w = get("10.0.0.2", "/proxy/fake/2.m3u8")
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+	_, ok := runtime.Proxy.existing(provider, "2")
+	assert.Assert(t, !ok)
+}
+
+func TestStreamProxySequence(t *testing.T) {
+	provider := &fakeProvider{name: "fake", channels: []ProviderChannel{{ID: "F-1", Key: "1"}}}
+	defer withProviders(provider)()
+	runtime := RuntimeUtils{}
+	runtime.Proxy = NewStreamProxy(&runtime, 2, time.Minute)
+	router := mux.NewRouter()
+	router.HandleFunc("/proxy/{provider}/{chan}.m3u8", ServeProxyPlaylist(runtime))
+
+	// A session continuing the sequence of a closed one marks where viewers switch
+	ready := make(chan struct{})
+	close(ready)
+	runtime.Proxy.sessions["fake/1"] = &sharedSession{name: "fake/1", cancel: func() {}, ready: ready, viewers: map[string]time.Time{}, started: time.Now(),
+		segments: []sharedSegment{{Sequence: 5, Duration: 6, Discontinuity: true}}, sequence: 6}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "http://local/proxy/fake/1.m3u8", nil))
+	assert.Equal(t, w.Body.String(), "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:5\n"+
+		"#EXT-X-DISCONTINUITY\n#EXTINF:6.000,\nhttp://local/proxy/fake/1/5.ts\n")
+
+	// The next session of a channel numbers its segments after the last one
+	runtime.Proxy.expire(time.Now().Add(time.Hour))
+	session := runtime.Proxy.session(provider, "1")
+	session.cancel()
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	assert.Equal(t, session.sequence, int64(6))
+}
@@ -0,0 +1,395 @@
+package sstv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const recordingRulesStateFile = "rules.json"
+
+// reairWindow How far apart the same event may air and still be considered a re-air
+const reairWindow = 72 * time.Hour
+
+// ErrUnknownRule returned for rule ids that do not exist
+var ErrUnknownRule = errors.New("unknown recording rule")
+
+// RecordingRule Records every sstv event matching its keywords or categories
+type RecordingRule struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Keywords   []string `json:"keywords"`
+	Categories []string `json:"categories"`
+	// Channels limits the rule to these tvg-ids, empty for all
+	Channels []string `json:"channels,omitempty"`
+	// Weekdays limits the rule to events starting on these days, like "mon", empty for all
+	Weekdays    []string `json:"weekdays,omitempty"`
+	PrePadding  string   `json:"prePadding,omitempty"`
+	PostPadding string   `json:"postPadding,omitempty"`
+	// MaxRecordings completed recordings to keep, 0 keeps all
+	MaxRecordings int       `json:"maxRecordings"`
+	Created       time.Time `json:"created"`
+}
+
+// validate Check the rule can match anything and its paddings parse
+func (rule RecordingRule) validate() error {
+	if len(rule.Keywords) == 0 && len(rule.Categories) == 0 {
+		return errors.New("rule needs keywords or categories")
+	}
+	for _, day := range rule.Weekdays {
+		if _, ok := parseWeekday(day); !ok {
+			return fmt.Errorf("invalid weekday %s", day)
+		}
+	}
+	if _, err := parsePadding(rule.PrePadding, 0); err != nil {
+		return fmt.Errorf("invalid prePadding: %s", err)
+	}
+	if _, err := parsePadding(rule.PostPadding, 0); err != nil {
+		return fmt.Errorf("invalid postPadding: %s", err)
+	}
+	if rule.MaxRecordings < 0 {
+		return errors.New("maxRecordings can not be negative")
+	}
+	return nil
+}
+
+func parseWeekday(day string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if strings.EqualFold(day, name) || strings.EqualFold(day, name[:3]) {
+			return d, true
+		}
+	}
+	return time.Sunday, false
+}
+
+// Matches Whether an event on a channel should be recorded by the rule, on weekdays of the EPG zone
+func (rule RecordingRule) Matches(channelID string, event SSEpgEvent) bool {
+	if len(rule.Channels) > 0 && !containsString(rule.Channels, channelID) {
+		return false
+	}
+	if len(rule.Weekdays) > 0 {
+		found := false
+		for _, day := range rule.Weekdays {
+			if d, ok := parseWeekday(day); ok && d == event.Start.In(epgLocation()).Weekday() {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return VirtualChannel{Keywords: rule.Keywords, Categories: rule.Categories}.Matches(event)
+}
+
+// loadRules Read persisted recording rules
+func (rec *Recorder) loadRules() error {
+	data, err := ioutil.ReadFile(filepath.Join(rec.dir, recordingRulesStateFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var rules []*RecordingRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("could not parse %s: %s", recordingRulesStateFile, err)
+	}
+	for _, rule := range rules {
+		rec.rules[rule.ID] = rule
+	}
+	return nil
+}
+
+// saveRules Persist all rules, callers must hold rec.mu
+func (rec *Recorder) saveRules() {
+	data, err := json.MarshalIndent(rec.listRules(), "", "  ")
+	if err != nil {
+		log.Printf("Could not marshal recording rules: %s", err)
+		return
+	}
+	path := filepath.Join(rec.dir, recordingRulesStateFile)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		log.Printf("Could not save recording rules: %s", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("Could not save recording rules: %s", err)
+	}
+}
+
+// listRules Rules sorted by creation, callers must hold rec.mu
+func (rec *Recorder) listRules() []RecordingRule {
+	result := []RecordingRule{}
+	for _, rule := range rec.rules {
+		result = append(result, *rule)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+	return result
+}
+
+// Rules All recording rules
+func (rec *Recorder) Rules() []RecordingRule {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.listRules()
+}
+
+// AddRule Validate and store a rule
+func (rec *Recorder) AddRule(rule RecordingRule) (RecordingRule, error) {
+	if err := rule.validate(); err != nil {
+		return rule, err
+	}
+	rule.ID = newRecordingID()
+	rule.Created = time.Now()
+	if len(rule.Name) == 0 {
+		rule.Name = strings.Join(append(append([]string{}, rule.Keywords...), rule.Categories...), ", ")
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.rules[rule.ID] = &rule
+	rec.saveRules()
+	return rule, nil
+}
+
+// DeleteRule Remove a rule and cancel the recordings it scheduled that have not started
+func (rec *Recorder) DeleteRule(id string) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if _, ok := rec.rules[id]; !ok {
+		return ErrUnknownRule
+	}
+	delete(rec.rules, id)
+	for rid, r := range rec.recordings {
+		if r.Rule == id && r.Status == RecordingScheduled {
+			delete(rec.recordings, rid)
+		}
+	}
+	rec.saveRules()
+	rec.flagConflicts()
+	rec.save()
+	return nil
+}
+
+// isDuplicate Whether an equivalent event is already recorded, scheduled or cancelled by the user.
+// Failed recordings do not count so rules retry them, callers must hold rec.mu
+func (rec *Recorder) isDuplicate(r Recording) bool {
+	for _, existing := range rec.recordings {
+		if existing.Status == RecordingFailed {
+			continue
+		}
+		if existing.Channel == r.Channel && existing.Start.Equal(r.Start) {
+			return true
+		}
+		// Re-airs have the same title and description on another channel or time.
+		// Without a description, like daily news, the title alone does not make a re-air
+		diff := existing.Start.Sub(r.Start)
+		if diff < 0 {
+			diff = -diff
+		}
+		if len(r.Description) > 0 && diff < reairWindow && strings.EqualFold(existing.Title, r.Title) && existing.Description == r.Description {
+			return true
+		}
+	}
+	return false
+}
+
+// dropFailed Forget failed recordings of the airing r retries, callers must hold rec.mu
+func (rec *Recorder) dropFailed(r Recording) {
+	for id, existing := range rec.recordings {
+		if existing.Status == RecordingFailed && existing.Channel == r.Channel && existing.Start.Equal(r.Start) {
+			if err := rec.removeFiles(*existing); err != nil {
+				log.Printf("Could not remove %s: %s", existing.File, err)
+			}
+			delete(rec.recordings, id)
+		}
+	}
+}
+
+// ApplyRules Schedule recordings for every event in epg matching a rule
+func (rec *Recorder) ApplyRules(runtime RuntimeUtils, epg SSEpg) {
+	cfg := GetConfig()
+	now := time.Now()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	scheduled := 0
+	for _, rule := range rec.rules {
+		pre, _ := parsePadding(rule.PrePadding, cfg.RecordingPrePadding)
+		post, _ := parsePadding(rule.PostPadding, cfg.RecordingPostPadding)
+		for _, channel := range epg.Channels {
+			chanID := ssChannelID(channel.Number)
+			for _, event := range channel.Events {
+				if !event.Stop.After(now) || !event.Stop.After(event.Start) || !rule.Matches(chanID, event) {
+					continue
+				}
+				r := Recording{
+					ID:          newRecordingID(),
+					Rule:        rule.ID,
+					Channel:     chanID,
+					Provider:    "sstv",
+					Key:         channel.Number,
+					Title:       event.Name,
+					Description: event.Description,
+					Category:    event.Category,
+					Start:       event.Start,
+					Stop:        event.Stop,
+					RecordFrom:  event.Start.Add(-pre),
+					RecordUntil: event.Stop.Add(post),
+					Status:      RecordingScheduled,
+					Created:     now,
+				}
+				if rec.isDuplicate(r) {
+					continue
+				}
+				rec.dropFailed(r)
+				rec.recordings[r.ID] = &r
+				scheduled++
+				log.Printf("Rule %s scheduled recording %s of %s on %s", rule.Name, r.ID, r.Title, r.Channel)
+			}
+		}
+	}
+	rec.enforceMaxRecordings()
+	rec.flagConflicts()
+	rec.save()
+	if scheduled > 0 {
+		log.Printf("Recording rules scheduled %d recordings", scheduled)
+	}
+}
+
+// enforceMaxRecordings Delete the oldest completed recordings of rules over their cap, callers must hold rec.mu
+func (rec *Recorder) enforceMaxRecordings() {
+	for _, rule := range rec.rules {
+		if rule.MaxRecordings == 0 {
+			continue
+		}
+		var completed []*Recording
+		for _, r := range rec.recordings {
+			if r.Rule == rule.ID && r.Status == RecordingCompleted {
+				completed = append(completed, r)
+			}
+		}
+		if len(completed) <= rule.MaxRecordings {
+			continue
+		}
+		sort.Slice(completed, func(i, j int) bool {
+			return completed[i].Start.After(completed[j].Start)
+		})
+		for _, r := range completed[rule.MaxRecordings:] {
+			if err := rec.removeFiles(*r); err != nil {
+				log.Printf("Could not remove %s: %s", r.File, err)
+				continue
+			}
+			log.Printf("Rule %s keeps %d recordings, removed %s", rule.Name, rule.MaxRecordings, r.ID)
+			delete(rec.recordings, r.ID)
+		}
+	}
+}
+
+// flagConflicts Mark pending sstv recordings overlapping more than the account allows, callers must hold rec.mu
+func (rec *Recorder) flagConflicts() {
+	limit := GetConfig().AccountConnectionLimit
+	var pending []*Recording
+	for _, r := range rec.recordings {
+		r.Conflict = false
+		if r.Provider == "sstv" && (r.Status == RecordingScheduled || r.Status == RecordingActive) {
+			pending = append(pending, r)
+		}
+	}
+	if limit <= 0 {
+		return
+	}
+	for _, r := range pending {
+		// Concurrency only increases at recording starts, so checking those is enough
+		for _, at := range pending {
+			if at.RecordFrom.Before(r.RecordFrom) || !at.RecordFrom.Before(r.RecordUntil) {
+				continue
+			}
+			var overlapping []*Recording
+			for _, other := range pending {
+				if !other.RecordFrom.After(at.RecordFrom) && other.RecordUntil.After(at.RecordFrom) {
+					overlapping = append(overlapping, other)
+				}
+			}
+			if len(overlapping) > limit {
+				for _, o := range overlapping {
+					o.Conflict = true
+				}
+			}
+		}
+	}
+}
+
+// ServeAPIRecordingRules List (GET) or add (POST) recording rules
+func ServeAPIRecordingRules(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if runtime.Recorder == nil {
+			writeJSONError(w, http.StatusNotFound, errors.New("recording is not enabled"))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, runtime.Recorder.Rules())
+		case http.MethodPost:
+			var rule RecordingRule
+			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+			rule, err := runtime.Recorder.AddRule(rule)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+			epgChan := make(chan SSEpg)
+			go getSsEpg(runtime, epgChan)
+			runtime.Recorder.ApplyRules(runtime, <-epgChan)
+			writeJSON(w, http.StatusCreated, rule)
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	}
+}
+
+// ServeAPIRecordingRule Show (GET) or remove (DELETE) a recording rule
+func ServeAPIRecordingRule(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if runtime.Recorder == nil {
+			writeJSONError(w, http.StatusNotFound, errors.New("recording is not enabled"))
+			return
+		}
+		id := mux.Vars(r)["id"]
+		switch r.Method {
+		case http.MethodGet:
+			for _, rule := range runtime.Recorder.Rules() {
+				if rule.ID == id {
+					writeJSON(w, http.StatusOK, rule)
+					return
+				}
+			}
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("no recording rule found for %s", id))
+		case http.MethodDelete:
+			err := runtime.Recorder.DeleteRule(id)
+			if err == ErrUnknownRule {
+				writeJSONError(w, http.StatusNotFound, fmt.Errorf("no recording rule found for %s", id))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	}
+}
@@ -12,5 +12,17 @@ type CacheClient interface {
 
 // RuntimeUtils should contain everything external
 type RuntimeUtils struct {
-	Cache CacheClient
+	Cache     CacheClient
+	Recorder  *Recorder
+	Timeshift *Timeshift
+	Proxy     *StreamProxy
+	Sessions  *SessionTracker
+	Health    *HealthChecker
+	Grabbers  *EPGGrabbers
+	// Egress proxies for upstream requests, direct when nil
+	Egress *Egress
+	// SavedSearches maps playlist names to search queries, served as /c/saved/{name}
+	SavedSearches map[string]string
+	// Profiles Xtream Codes logins, the Xtream API is disabled without any
+	Profiles []Profile
 }
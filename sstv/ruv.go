@@ -0,0 +1,130 @@
+package sstv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ruvOpenURL Stream of a ruv channel that is not geoblocked
+const ruvOpenURL = "http://ruvruv-live.hls.adaptive.level3.net/ruv/%s/index.m3u8"
+
+var ruvClient = &http.Client{Timeout: 10 * time.Second}
+
+// ruvResolver Resolves ruv streams from the geoblocked api and the open endpoint
+type ruvResolver struct {
+	apiURL  string
+	openURL string
+	// geoFirst tries the geoblocked api before the open endpoint
+	geoFirst bool
+	ttl      time.Duration
+	// client for the api and for checking streams
+	client *http.Client
+}
+
+func newRuvResolver(cfg Config) ruvResolver {
+	return ruvResolver{
+		apiURL:   cfg.RuvAPIURL,
+		openURL:  ruvOpenURL,
+		geoFirst: cfg.RuvUseGeoblocked,
+		ttl:      cfg.RuvCacheTTL,
+		client:   ruvClient,
+	}
+}
+
+// geo Stream url from the geoblocked api
+func (res ruvResolver) geo(channel string) (string, error) {
+	u, err := url.Parse(res.apiURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid ruv api url: %s", err)
+	}
+	query := u.Query()
+	query.Set("channel", channel)
+	u.RawQuery = query.Encode()
+
+	resp, err := res.client.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ruv api returned status %d", resp.StatusCode)
+	}
+	var result RuvChannelResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid ruv api response: %s", err)
+	}
+	if len(result.Result) == 0 {
+		return "", errors.New("ruv api returned no streams")
+	}
+	stream, err := url.Parse(result.Result[0])
+	if err != nil || (stream.Scheme != "http" && stream.Scheme != "https") || len(stream.Host) == 0 {
+		return "", fmt.Errorf("ruv api returned an invalid stream url %q", result.Result[0])
+	}
+	return stream.String(), nil
+}
+
+// open Stream url from the open endpoint
+func (res ruvResolver) open(channel string) (string, error) {
+	return fmt.Sprintf(res.openURL, channel), nil
+}
+
+// resolve Stream url of a channel, from the cache or the first endpoint that works
+func (res ruvResolver) resolve(runtime RuntimeUtils, channel string) (string, error) {
+	cacheKey := fmt.Sprintf("ruvStream_%s", channel)
+	if runtime.Cache != nil {
+		if val, err := runtime.Cache.Get(cacheKey); err == nil && len(val) > 0 {
+			return val, nil
+		}
+	}
+
+	type endpoint struct {
+		name    string
+		resolve func(string) (string, error)
+	}
+	endpoints := []endpoint{{"geo", res.geo}, {"open", res.open}}
+	if !res.geoFirst {
+		endpoints[0], endpoints[1] = endpoints[1], endpoints[0]
+	}
+	// Endpoints are sources of the channel, failed ones are skipped like failed static sources
+	now := time.Now()
+	source := func(e endpoint) string {
+		return fmt.Sprintf("ruv/%s/%s", e.name, channel)
+	}
+	var candidates []endpoint
+	for _, e := range endpoints {
+		if !sourceCoolingDown(source(e), now) {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = endpoints
+	}
+
+	var lastErr error
+	for i, e := range candidates {
+		stream, err := e.resolve(channel)
+		// The last endpoint is used without a check, there is nothing left to fall back to
+		if err == nil && i < len(candidates)-1 {
+			err = checkSource(res.client, stream)
+		}
+		if err != nil {
+			log.Printf("RUV: %s endpoint failed for %s: %s", e.name, channel, err)
+			markSourceFailed(source(e), now)
+			lastErr = err
+			continue
+		}
+		if runtime.Cache != nil {
+			if err := runtime.Cache.Set(cacheKey, stream, res.ttl); err != nil {
+				log.Printf("RUV: could not cache %s: %s", channel, err)
+			}
+		}
+		return stream, nil
+	}
+	return "", fmt.Errorf("could not resolve ruv stream for %s: %s", channel, lastErr)
+}
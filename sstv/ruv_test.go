@@ -0,0 +1,96 @@
+package sstv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func resetSourceCooldowns() {
+	sourceCooldowns.Lock()
+	defer sourceCooldowns.Unlock()
+	sourceCooldowns.until = make(map[string]time.Time)
+}
+
+func TestRuvResolver(t *testing.T) {
+	defer resetSourceCooldowns()
+	apiCalls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api":
+			apiCalls++
+			switch r.URL.Query().Get("channel") {
+			case "ruv":
+				w.Write([]byte(`{"result": ["http://` + r.Host + `/geo/ruv.m3u8"]}`))
+			case "ruv2":
+				w.Write([]byte(`{"result": []}`))
+			default:
+				w.WriteHeader(500)
+			}
+		case "/geo/ruv.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXTINF:1.0,\nseg0.ts\n"))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+	resolver := ruvResolver{apiURL: ts.URL + "/api?format=json", openURL: ts.URL + "/open/%s.m3u8", geoFirst: true, ttl: 10 * time.Minute, client: ruvClient}
+
+	tests := []struct {
+		channel string
+		url     string
+	}{
+		{"ruv", ts.URL + "/geo/ruv.m3u8"},
+		// An empty api result falls back to the open endpoint
+		{"ruv2", ts.URL + "/open/ruv2.m3u8"},
+		{"broken", ts.URL + "/open/broken.m3u8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			url, err := resolver.resolve(RuntimeUtils{}, tt.channel)
+			assert.NilError(t, err)
+			assert.Equal(t, url, tt.url)
+		})
+	}
+
+	// The failed api is skipped while it cools down
+	apiCalls = 0
+	url, err := resolver.resolve(RuntimeUtils{}, "ruv2")
+	assert.NilError(t, err)
+	assert.Equal(t, url, ts.URL+"/open/ruv2.m3u8")
+	assert.Equal(t, apiCalls, 0)
+	resetSourceCooldowns()
+
+	// Open first, the geoblocked api is the fallback and returns an error when it fails
+	open := resolver
+	open.geoFirst = false
+	open.openURL = ts.URL + "/missing/%s.m3u8"
+	url, err = open.resolve(RuntimeUtils{}, "ruv")
+	assert.NilError(t, err)
+	assert.Equal(t, url, ts.URL+"/geo/ruv.m3u8")
+	_, err = open.resolve(RuntimeUtils{}, "ruv2")
+	assert.ErrorContains(t, err, "no streams")
+
+	// Resolved urls are cached
+	resolver.ttl = 90 * time.Second
+	cached := map[string]string{}
+	runtime := RuntimeUtils{Cache: &FakeCache{
+		GetFunc: func(key string) (string, error) { return cached[key], nil },
+		SetFunc: func(key string, value string, exp time.Duration) error {
+			// Not rounded down to whole minutes
+			assert.Equal(t, exp, 90*time.Second)
+			cached[key] = value
+			return nil
+		},
+	}}
+	url, err = resolver.resolve(runtime, "ruv")
+	assert.NilError(t, err)
+	assert.Equal(t, cached["ruvStream_ruv"], url)
+	cached["ruvStream_ruv"] = "http://cached/ruv.m3u8"
+	url, err = resolver.resolve(runtime, "ruv")
+	assert.NilError(t, err)
+	assert.Equal(t, url, "http://cached/ruv.m3u8")
+}
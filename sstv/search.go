@@ -0,0 +1,304 @@
+package sstv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Field weights used when ranking search results
+const (
+	searchTitleWeight       = 3.0
+	searchCategoryWeight    = 2.0
+	searchDescriptionWeight = 1.0
+	searchPrefixPenalty     = 0.5
+	searchPhraseBoost       = 1.5
+	searchMinPrefixLength   = 3
+)
+
+// SearchResult A programme matching a search query
+type SearchResult struct {
+	APIProgramme
+	ChannelName string  `json:"channelName,omitempty"`
+	Logo        string  `json:"logo,omitempty"`
+	URL         string  `json:"url,omitempty"`
+	Score       float64 `json:"score"`
+}
+
+type searchDoc struct {
+	prog     APIProgramme
+	provider Provider
+	channel  *ProviderChannel
+}
+
+type searchPosting struct {
+	doc    int
+	weight float64
+}
+
+type searchHit struct {
+	doc   int
+	score float64
+}
+
+// SearchIndex Inverted index over programme titles, descriptions and categories
+type SearchIndex struct {
+	mu       sync.RWMutex
+	docs     []searchDoc
+	postings map[string][]searchPosting
+	built    time.Time
+
+	building sync.Mutex
+}
+
+var searchIndex *SearchIndex
+var searchIndexOnce sync.Once
+
+// GetSearchIndex Global search index, rebuilt whenever the sstv feed is refreshed
+func GetSearchIndex() *SearchIndex {
+	searchIndexOnce.Do(func() {
+		searchIndex = &SearchIndex{}
+		OnFeedRefresh(func(runtime RuntimeUtils, epg SSEpg) {
+			searchIndex.Build(runtime)
+		})
+	})
+	return searchIndex
+}
+
+// tokenize Lowercase words and numbers of s
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Build Rebuild the index from the combined EPG
+func (idx *SearchIndex) Build(runtime RuntimeUtils) {
+	idx.building.Lock()
+	defer idx.building.Unlock()
+
+	channels := make(map[string]searchDoc)
+	for _, p := range Providers() {
+		providerChannels, err := p.Channels(runtime)
+		if err != nil {
+			log.Printf("Provider %s: could not list channels: %s", p.Name(), err)
+			continue
+		}
+		for i := range providerChannels {
+			channels[providerChannels[i].ID] = searchDoc{provider: p, channel: &providerChannels[i]}
+		}
+	}
+
+	var docs []searchDoc
+	for _, prog := range getGuide(runtime).Programme {
+		apiProg, err := toAPIProgramme(prog)
+		if err != nil {
+			continue
+		}
+		doc := channels[prog.Channel]
+		doc.prog = apiProg
+		docs = append(docs, doc)
+	}
+	idx.index(docs)
+	log.Printf("Search index built with %d programmes", len(docs))
+}
+
+// index Replace the indexed documents
+func (idx *SearchIndex) index(docs []searchDoc) {
+	postings := make(map[string][]searchPosting)
+	for i, doc := range docs {
+		weights := make(map[string]float64)
+		for _, token := range tokenize(doc.prog.Title) {
+			weights[token] += searchTitleWeight
+		}
+		for _, token := range tokenize(doc.prog.Category) {
+			weights[token] += searchCategoryWeight
+		}
+		for _, token := range tokenize(doc.prog.Description) {
+			weights[token] += searchDescriptionWeight
+		}
+		for token, weight := range weights {
+			postings[token] = append(postings[token], searchPosting{doc: i, weight: weight})
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.docs = docs
+	idx.postings = postings
+	idx.built = time.Now()
+}
+
+// Built When the index was last built, zero if never
+func (idx *SearchIndex) Built() time.Time {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.built
+}
+
+// search Programmes overlapping start-end that match every term of q, best first.
+// Callers must hold idx.mu
+func (idx *SearchIndex) search(q string, start time.Time, end time.Time) []searchHit {
+	terms := tokenize(q)
+	if len(terms) == 0 {
+		return nil
+	}
+	phrase := strings.ToLower(strings.TrimSpace(q))
+
+	total := float64(len(idx.docs))
+	scores := make(map[int]float64)
+	matched := make(map[int]int)
+	for _, term := range terms {
+		termScores := make(map[int]float64)
+		for token, postings := range idx.postings {
+			factor := 1.0
+			if token != term {
+				if len(term) < searchMinPrefixLength || !strings.HasPrefix(token, term) {
+					continue
+				}
+				factor = searchPrefixPenalty
+			}
+			for _, posting := range postings {
+				termScores[posting.doc] = math.Max(termScores[posting.doc], posting.weight*factor)
+			}
+		}
+		idf := math.Log(1 + total/float64(len(termScores)+1))
+		for doc, score := range termScores {
+			scores[doc] += score * idf
+			matched[doc]++
+		}
+	}
+
+	var hits []searchHit
+	for doc, score := range scores {
+		prog := idx.docs[doc].prog
+		if matched[doc] < len(terms) || !prog.Stop.After(start) || !prog.Start.Before(end) {
+			continue
+		}
+		if len(terms) > 1 && strings.Contains(strings.ToLower(prog.Title), phrase) {
+			score *= searchPhraseBoost
+		}
+		hits = append(hits, searchHit{doc: doc, score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return idx.docs[hits[i].doc].prog.Start.Before(idx.docs[hits[j].doc].prog.Start)
+	})
+	return hits
+}
+
+// Search Ranked programmes overlapping start-end matching q
+func (idx *SearchIndex) Search(q string, start time.Time, end time.Time, baseURL string) []SearchResult {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	hits := idx.search(q, start, end)
+	results := []SearchResult{}
+	for _, hit := range hits {
+		doc := idx.docs[hit.doc]
+		result := SearchResult{APIProgramme: doc.prog, Score: hit.score}
+		if doc.channel != nil {
+			result.ChannelName = doc.channel.Name
+			result.Logo = doc.channel.Logo
+			result.URL = providerChannelURL(baseURL, doc.provider, *doc.channel)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// getSearchm3u m3u with one entry per channel airing, or about to air, an event matching q
+func getSearchm3u(runtime RuntimeUtils, c chan string, baseURL string, group string, q string) {
+	defer close(c)
+	c <- fmt.Sprintf("#EXTM3U x-tvg-url=\"%s/g\"\n", baseURL)
+
+	index := GetSearchIndex()
+	index.refresh(runtime)
+	searchm3u(c, index, baseURL, group, q, time.Now(), GetConfig().SearchPlaylistWindow)
+}
+
+// searchm3u Emit the best match per channel of events airing at now or starting within window.
+// Upcoming events are named with their start time
+func searchm3u(c chan string, index *SearchIndex, baseURL string, group string, q string, now time.Time, window time.Duration) {
+	results := index.Search(q, now, now.Add(window), baseURL)
+	seen := make(map[string]bool)
+	for _, result := range results {
+		if len(result.URL) == 0 || seen[result.Channel] {
+			continue
+		}
+		seen[result.Channel] = true
+		name := result.Title
+		if result.Start.After(now) {
+			name = fmt.Sprintf("%s (%s)", name, result.Start.In(epgLocation()).Format("15:04"))
+		}
+		c <- fmt.Sprintf("#EXTINF:-1 tvg-id=\"%s\" tvg-logo=\"%s\" group-title=\"%s\", %s\n",
+			m3uAttr(result.Channel), m3uAttr(result.Logo), m3uAttr(group), m3uTitle(name))
+		c <- fmt.Sprintf("%s\n", result.URL)
+	}
+}
+
+// refresh Build the index if it never was, otherwise let a changed feed trigger a rebuild
+func (idx *SearchIndex) refresh(runtime RuntimeUtils) {
+	if idx.Built().IsZero() {
+		idx.Build(runtime)
+		return
+	}
+	epgChan := make(chan SSEpg)
+	go getSsEpg(runtime, epgChan)
+	<-epgChan
+}
+
+// ServeAPISearch Search programmes by title, description and category
+func ServeAPISearch(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		if len(tokenize(q)) == 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("missing search query q"))
+			return
+		}
+		start, end, err := parseTimeRange(r, time.Now(), farFuture)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		index := GetSearchIndex()
+		index.refresh(runtime)
+
+		results := index.Search(q, start, end, getBaseURL(r))
+		page, from, to, err := paginate(r, len(results))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		page.Items = results[from:to]
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// LoadSavedSearches Read saved searches from a json object of playlist names to queries
+func LoadSavedSearches(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var searches map[string]string
+	if err := json.Unmarshal(data, &searches); err != nil {
+		return nil, err
+	}
+	for name, q := range searches {
+		if len(tokenize(q)) == 0 {
+			return nil, fmt.Errorf("saved search %s has no query", name)
+		}
+	}
+	return searches, nil
+}
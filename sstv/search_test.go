@@ -0,0 +1,120 @@
+package sstv
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gotest.tools/assert"
+)
+
+func TestSearchIndex(t *testing.T) {
+	now := time.Now()
+	doc := func(title string, category string, description string, startHours int) searchDoc {
+		return searchDoc{
+			prog: APIProgramme{
+				Title:       title,
+				Category:    category,
+				Description: description,
+				Start:       now.Add(time.Duration(startHours) * time.Hour),
+				Stop:        now.Add(time.Duration(startHours+2) * time.Hour),
+			},
+			provider: &ssProvider{},
+			channel:  &ProviderChannel{Key: "05", Name: "SS 05"},
+		}
+	}
+	idx := &SearchIndex{}
+	idx.index([]searchDoc{
+		doc("EPL: Arsenal vs Chelsea", "Soccer", "Liverpool fans watch closely", 1),
+		doc("EPL: Liverpool vs Everton", "Soccer", "Merseyside derby", 2),
+		doc("NHL: Bruins vs Leafs", "Ice Hockey", "", 1),
+		doc("Liverpool classics", "Soccer", "", -5),
+	})
+
+	tests := []struct {
+		name   string
+		q      string
+		titles []string
+	}{
+		{"TestTitleRanksAboveDescription", "liverpool", []string{"EPL: Liverpool vs Everton", "EPL: Arsenal vs Chelsea"}},
+		{"TestAllTermsRequired", "liverpool derby", []string{"EPL: Liverpool vs Everton"}},
+		{"TestPrefix", "liverp", []string{"EPL: Liverpool vs Everton", "EPL: Arsenal vs Chelsea"}},
+		{"TestCategory", "ice hockey", []string{"NHL: Bruins vs Leafs"}},
+		{"TestShortPrefixIgnored", "li", nil},
+		{"TestNoMatch", "cricket", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var titles []string
+			for _, result := range idx.Search(tt.q, now, farFuture, "http://base") {
+				titles = append(titles, result.Title)
+				assert.Equal(t, result.URL, "http://base/c/05")
+			}
+			assert.DeepEqual(t, titles, tt.titles)
+		})
+	}
+}
+
+func TestSearchm3u(t *testing.T) {
+	now := time.Date(2019, 2, 12, 18, 0, 0, 0, time.UTC)
+	doc := func(title string, channel string, startMinutes int) searchDoc {
+		return searchDoc{
+			prog: APIProgramme{
+				Channel: "SSTV-" + channel,
+				Title:   title,
+				Start:   now.Add(time.Duration(startMinutes) * time.Minute),
+				Stop:    now.Add(time.Duration(startMinutes+90) * time.Minute),
+			},
+			provider: &ssProvider{},
+			channel:  &ProviderChannel{Key: channel, Logo: "logo"},
+		}
+	}
+	idx := &SearchIndex{}
+	idx.index([]searchDoc{
+		doc("EPL: Arsenal vs Chelsea", "1", -30),
+		doc("EPL: Liverpool vs Everton", "1", 60),
+		// Titles and groups can not break out of their line or attribute
+		doc("EPL: Spurs vs Fulham\n#EXTM3U", "2", 15),
+		doc("EPL: Leeds vs Wolves", "3", 120),
+		doc("EPL: Burnley vs Watford", "4", -120),
+	})
+
+	c := make(chan string)
+	go func() {
+		defer close(c)
+		searchm3u(c, idx, "http://base", "EPL \"live\"", "epl", now, time.Hour)
+	}()
+	var result string
+	for line := range c {
+		result += line
+	}
+	assert.Equal(t, result, "#EXTINF:-1 tvg-id=\"SSTV-1\" tvg-logo=\"logo\" group-title=\"EPL 'live'\", EPL: Arsenal vs Chelsea\n"+
+		"http://base/c/1\n"+
+		"#EXTINF:-1 tvg-id=\"SSTV-2\" tvg-logo=\"logo\" group-title=\"EPL 'live'\", EPL: Spurs vs Fulham #EXTM3U (18:15)\n"+
+		"http://base/c/2\n")
+}
+
+func TestSearchPlaylistHandlers(t *testing.T) {
+	r := mux.NewRouter()
+	runtime := RuntimeUtils{SavedSearches: map[string]string{"epl": "premier league"}}
+	r.HandleFunc("/c/search", ServeSearchPlaylist(runtime))
+	r.HandleFunc("/c/saved/{name}", ServeSavedSearchPlaylist(runtime))
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"TestMissingQuery", "/c/search", 400},
+		{"TestEmptyQuery", "/c/search?q=+-", 400},
+		{"TestUnknownSavedSearch", "/c/saved/nhl", 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			assert.Equal(t, w.Code, tt.status)
+		})
+	}
+}
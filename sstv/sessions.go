@@ -0,0 +1,287 @@
+package sstv
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Ways a stream is delivered to a client
+const (
+	SessionRedirect = "redirect"
+	SessionProxy    = "proxy"
+	SessionTS       = "ts"
+	// SessionTimeshift streams play from a timeshift buffer, which holds the upstream connection
+	SessionTimeshift = "timeshift"
+)
+
+// ErrStreamLimit returned when a client or account has no streams left
+var ErrStreamLimit = errors.New("stream limit reached")
+
+// AccountProvider Optionally implemented by providers whose streams use an upstream account
+type AccountProvider interface {
+	Account() string
+}
+
+// providerAccount Upstream account used by a provider, empty if it has none
+func providerAccount(provider Provider) string {
+	if accounter, ok := provider.(AccountProvider); ok {
+		return accounter.Account()
+	}
+	return ""
+}
+
+// StreamSession A stream delivered to a client
+type StreamSession struct {
+	ID           string
+	Client       string
+	UserAgent    string
+	Provider     string
+	Channel      string
+	Account      string `json:",omitempty"`
+	Mode         string
+	Started      time.Time
+	LastActivity time.Time
+	// shared sessions are served from one upstream connection per channel
+	shared bool
+	cancel context.CancelFunc
+}
+
+// connection Identifies the upstream connection a session uses
+func (s *StreamSession) connection() string {
+	if s.shared {
+		return fmt.Sprintf("%s/%s", s.Provider, s.Channel)
+	}
+	return s.ID
+}
+
+// SessionTracker Keeps track of active streams and enforces stream limits
+type SessionTracker struct {
+	runtime      *RuntimeUtils
+	clientLimit  int
+	accountLimit int
+	// redirectTTL How long a redirected stream, which reports no activity, is assumed to play
+	redirectTTL time.Duration
+	// idle How long a stream reporting activity may be silent
+	idle     time.Duration
+	mu       sync.Mutex
+	sessions map[string]*StreamSession
+}
+
+// NewSessionTracker Tracker allowing clientLimit streams per client and accountLimit per account, 0 for no limit
+func NewSessionTracker(runtime *RuntimeUtils, clientLimit int, accountLimit int, redirectTTL time.Duration, idle time.Duration) *SessionTracker {
+	return &SessionTracker{
+		runtime:      runtime,
+		clientLimit:  clientLimit,
+		accountLimit: accountLimit,
+		redirectTTL:  redirectTTL,
+		idle:         idle,
+		sessions:     make(map[string]*StreamSession),
+	}
+}
+
+// sessionID Stable id of a client watching a channel
+func sessionID(viewer string, provider string, channel string) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%s|%s", viewer, provider, channel)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Start Track a stream to the client of r, failing with ErrStreamLimit when limits are reached.
+// Redirected streams report no activity, so the oldest of them is assumed abandoned when the client needs room.
+func (t *SessionTracker) Start(r *http.Request, provider Provider, channel string, mode string) (StreamSession, error) {
+	now := time.Now()
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	id := sessionID(viewerID(r), provider.Name(), channel)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+	if existing, ok := t.sessions[id]; ok {
+		existing.Mode = mode
+		existing.LastActivity = now
+		return *existing, nil
+	}
+
+	session := &StreamSession{
+		ID:           id,
+		Client:       client,
+		UserAgent:    r.UserAgent(),
+		Provider:     provider.Name(),
+		Channel:      channel,
+		Account:      providerAccount(provider),
+		Mode:         mode,
+		Started:      now,
+		LastActivity: now,
+		shared:       t.runtime.Proxy != nil && mode != SessionRedirect,
+	}
+	for !t.allowed(session) {
+		oldest := t.oldestRedirect(session.Client)
+		if oldest == nil {
+			return StreamSession{}, ErrStreamLimit
+		}
+		log.Printf("Sessions: %s started %s/%s, dropping %s/%s", client, session.Provider, channel, oldest.Provider, oldest.Channel)
+		delete(t.sessions, oldest.ID)
+	}
+	t.sessions[id] = session
+	return *session, nil
+}
+
+// allowed Whether session fits within the client and account limits
+func (t *SessionTracker) allowed(session *StreamSession) bool {
+	clientStreams := 0
+	connections := make(map[string]bool)
+	for _, other := range t.sessions {
+		if other.Client == session.Client {
+			clientStreams++
+		}
+		if len(session.Account) > 0 && other.Account == session.Account && other.Mode != SessionTimeshift {
+			connections[other.connection()] = true
+		}
+	}
+	if t.clientLimit > 0 && clientStreams >= t.clientLimit {
+		return false
+	}
+	if t.accountLimit <= 0 || len(session.Account) == 0 || connections[session.connection()] {
+		return true
+	}
+	if session.Mode == SessionTimeshift && t.runtime.Timeshift != nil && t.runtime.Timeshift.running(session.Provider, session.Channel) {
+		return true
+	}
+	// Recordings, timeshift buffers and health probes hold account connections outside of sessions
+	used := len(connections)
+	if t.runtime.Recorder != nil {
+		used += t.runtime.Recorder.activeOn(session.Account)
+	}
+	if t.runtime.Timeshift != nil {
+		used += t.runtime.Timeshift.activeOn(session.Account)
+	}
+	if t.runtime.Health != nil {
+		used += t.runtime.Health.activeOn(session.Account)
+	}
+	return used < t.accountLimit
+}
+
+// oldestRedirect Oldest redirected stream of a client, nil if there is none
+func (t *SessionTracker) oldestRedirect(client string) *StreamSession {
+	var oldest *StreamSession
+	for _, session := range t.sessions {
+		if session.Client == client && session.Mode == SessionRedirect && (oldest == nil || session.Started.Before(oldest.Started)) {
+			oldest = session
+		}
+	}
+	return oldest
+}
+
+// expire Forget sessions that stopped before now, caller must hold the lock
+func (t *SessionTracker) expire(now time.Time) {
+	for id, session := range t.sessions {
+		switch session.Mode {
+		case SessionRedirect:
+			if now.Sub(session.Started) > t.redirectTTL {
+				delete(t.sessions, id)
+			}
+		case SessionProxy, SessionTimeshift:
+			if now.Sub(session.LastActivity) > t.idle {
+				delete(t.sessions, id)
+			}
+		}
+	}
+}
+
+// Touch Record activity of the client of r on a channel
+func (t *SessionTracker) Touch(r *http.Request, provider string, channel string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if session, ok := t.sessions[sessionID(viewerID(r), provider, channel)]; ok {
+		session.LastActivity = time.Now()
+	}
+}
+
+// attach Let End and Kick stop a running stream
+func (t *SessionTracker) attach(id string, cancel context.CancelFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if session, ok := t.sessions[id]; ok {
+		session.cancel = cancel
+	}
+}
+
+// End Stop tracking a session, stopping its stream if it is running
+func (t *SessionTracker) End(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	session, ok := t.sessions[id]
+	if !ok {
+		return false
+	}
+	if session.cancel != nil {
+		session.cancel()
+	}
+	delete(t.sessions, id)
+	return true
+}
+
+// List Active sessions, oldest first
+func (t *SessionTracker) List() []StreamSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(time.Now())
+	result := make([]StreamSession, 0, len(t.sessions))
+	for _, session := range t.sessions {
+		result = append(result, *session)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Started.Before(result[j].Started)
+	})
+	return result
+}
+
+// writeStreamLimit Respond to a stream refused by the session tracker
+func writeStreamLimit(w http.ResponseWriter, channel string) {
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(fmt.Sprintf("Too many streams, stop one before watching %s", channel)))
+}
+
+// ServeAPISessions List active stream sessions
+func ServeAPISessions(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if runtime.Sessions == nil {
+			writeJSONError(w, http.StatusNotFound, errors.New("session tracking is not enabled"))
+			return
+		}
+		writeJSON(w, http.StatusOK, runtime.Sessions.List())
+	}
+}
+
+// ServeAPISession End (DELETE) a stream session
+func ServeAPISession(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if runtime.Sessions == nil {
+			writeJSONError(w, http.StatusNotFound, errors.New("session tracking is not enabled"))
+			return
+		}
+		if r.Method != http.MethodDelete {
+			writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		id := mux.Vars(r)["id"]
+		if !runtime.Sessions.End(id) {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("no session found for %s", id))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
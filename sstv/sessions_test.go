@@ -0,0 +1,135 @@
+package sstv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gotest.tools/assert"
+)
+
+// accountProvider fakeProvider streaming from an upstream account
+type accountProvider struct {
+	fakeProvider
+}
+
+func (p *accountProvider) Account() string {
+	return "account"
+}
+
+func clientRequest(client string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = client + ":1234"
+	return r
+}
+
+func TestSessionTrackerLimits(t *testing.T) {
+	provider := &accountProvider{fakeProvider{name: "fake"}}
+	tracker := NewSessionTracker(&RuntimeUtils{}, 1, 1, time.Hour, time.Minute)
+
+	first, err := tracker.Start(clientRequest("10.0.0.1"), provider, "1", SessionRedirect)
+	assert.NilError(t, err)
+	// Zapping replaces the redirected stream of the same client
+	_, err = tracker.Start(clientRequest("10.0.0.1"), provider, "2", SessionRedirect)
+	assert.NilError(t, err)
+	sessions := tracker.List()
+	assert.Equal(t, len(sessions), 1)
+	assert.Equal(t, sessions[0].Channel, "2")
+	assert.Equal(t, sessions[0].Account, "account")
+	assert.Assert(t, sessions[0].ID != first.ID)
+
+	// Another client can not take the only account stream
+	_, err = tracker.Start(clientRequest("10.0.0.2"), provider, "3", SessionRedirect)
+	assert.Equal(t, err, ErrStreamLimit)
+	// Unless it streams from another account
+	_, err = tracker.Start(clientRequest("10.0.0.2"), &fakeProvider{name: "free"}, "3", SessionRedirect)
+	assert.NilError(t, err)
+
+	// Streams reporting activity are not replaced
+	tracker.End(sessions[0].ID)
+	ts, err := tracker.Start(clientRequest("10.0.0.1"), provider, "4", SessionTS)
+	assert.NilError(t, err)
+	_, err = tracker.Start(clientRequest("10.0.0.1"), provider, "5", SessionRedirect)
+	assert.Equal(t, err, ErrStreamLimit)
+	assert.Assert(t, tracker.End(ts.ID))
+
+	tracker.mu.Lock()
+	tracker.expire(time.Now().Add(2 * time.Hour))
+	tracker.mu.Unlock()
+	assert.Equal(t, len(tracker.List()), 0)
+}
+
+func TestSessionTrackerSharedConnections(t *testing.T) {
+	provider := &accountProvider{fakeProvider{name: "fake"}}
+	tracker := NewSessionTracker(&RuntimeUtils{Proxy: NewStreamProxy(&RuntimeUtils{}, 1, time.Minute)}, 0, 1, time.Hour, time.Minute)
+
+	_, err := tracker.Start(clientRequest("10.0.0.1"), provider, "1", SessionProxy)
+	assert.NilError(t, err)
+	// Proxied viewers of the same channel share the upstream connection
+	_, err = tracker.Start(clientRequest("10.0.0.2"), provider, "1", SessionProxy)
+	assert.NilError(t, err)
+	_, err = tracker.Start(clientRequest("10.0.0.2"), provider, "2", SessionProxy)
+	assert.Equal(t, err, ErrStreamLimit)
+
+	tracker.mu.Lock()
+	tracker.expire(time.Now().Add(2 * time.Minute))
+	tracker.mu.Unlock()
+	_, err = tracker.Start(clientRequest("10.0.0.2"), provider, "2", SessionProxy)
+	assert.NilError(t, err)
+}
+
+func TestSessionTrackerBackgroundConnections(t *testing.T) {
+	provider := &accountProvider{fakeProvider{name: "fake"}}
+	timeshift := &Timeshift{buffers: map[string]*timeshiftBuffer{"fake/1": {account: "account"}}}
+	health := NewHealthChecker(&RuntimeUtils{}, time.Hour, 1, nil)
+	tracker := NewSessionTracker(&RuntimeUtils{Timeshift: timeshift, Health: health}, 0, 2, time.Hour, time.Minute)
+
+	// A timeshift buffer and a health probe hold both account connections
+	health.addProbing("account", 1)
+	_, err := tracker.Start(clientRequest("10.0.0.1"), provider, "2", SessionTS)
+	assert.Equal(t, err, ErrStreamLimit)
+
+	health.addProbing("account", -1)
+	_, err = tracker.Start(clientRequest("10.0.0.1"), provider, "2", SessionTS)
+	assert.NilError(t, err)
+}
+
+func TestServeAPISessions(t *testing.T) {
+	defer withProviders(&accountProvider{fakeProvider{name: "fake", channels: []ProviderChannel{{ID: "F-1", Key: "1"}, {ID: "F-2", Key: "2"}}}})()
+	runtime := RuntimeUtils{}
+	runtime.Sessions = NewSessionTracker(&runtime, 0, 1, time.Hour, time.Minute)
+	router := mux.NewRouter()
+	router.HandleFunc("/p/{provider}/{chan}", ServeProviderRedir(runtime))
+	router.HandleFunc("/api/sessions", ServeAPISessions(runtime))
+	router.HandleFunc("/api/sessions/{id}", ServeAPISession(runtime))
+
+	r := httptest.NewRequest("GET", "/p/fake/1", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusFound)
+
+	r = httptest.NewRequest("GET", "/p/fake/2", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/sessions", nil))
+	var sessions []StreamSession
+	assert.NilError(t, json.NewDecoder(w.Body).Decode(&sessions))
+	assert.Equal(t, len(sessions), 1)
+	assert.Equal(t, sessions[0].Client, "10.0.0.1")
+	assert.Equal(t, sessions[0].Mode, SessionRedirect)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/sessions/"+sessions[0].ID, nil))
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusFound)
+}
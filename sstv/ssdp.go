@@ -0,0 +1,202 @@
+package sstv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ssdpGroup       = "239.255.255.250:1900"
+	ssdpBasicDevice = "urn:schemas-upnp-org:device:Basic:1"
+	ssdpMaxAge      = 1800
+	ssdpServer      = "Linux/1.0 UPnP/1.0 sstv-go/1.0"
+	ssdpDevicePath  = "/device.xml"
+)
+
+// ssdpTarget A search target answered for an announced device
+type ssdpTarget struct {
+	ST   string
+	USN  string
+	Path string
+}
+
+// ssdpTargets Search targets of the announced HDHomeRun tuner
+func ssdpTargets() []ssdpTarget {
+	uuid := "uuid:" + deviceUUID(hdhomerunDevice)
+	return []ssdpTarget{
+		{"upnp:rootdevice", uuid + "::upnp:rootdevice", ssdpDevicePath},
+		{uuid, uuid, ssdpDevicePath},
+		{ssdpBasicDevice, uuid + "::" + ssdpBasicDevice, ssdpDevicePath},
+	}
+}
+
+// matchSearch Targets answering an M-SEARCH for st
+func matchSearch(st string) []ssdpTarget {
+	var result []ssdpTarget
+	for _, target := range ssdpTargets() {
+		if st == "ssdp:all" || st == target.ST {
+			result = append(result, target)
+		}
+	}
+	return result
+}
+
+// SSDPResponder Announces sstv-go as an HDHomeRun tuner on the local network
+type SSDPResponder struct {
+	port    string
+	baseURL string
+	mu      sync.Mutex
+	conn    *net.UDPConn
+	done    chan struct{}
+}
+
+// NewSSDPResponder Responder announcing the http server on port, at baseURL if it is set
+func NewSSDPResponder(port string, baseURL string) *SSDPResponder {
+	return &SSDPResponder{port: port, baseURL: baseURL, done: make(chan struct{})}
+}
+
+// Run Answer searches and announce periodically until Close
+func (s *SSDPResponder) Run() error {
+	group, err := net.ResolveUDPAddr("udp4", ssdpGroup)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	log.Printf("SSDP: announcing on %s", ssdpGroup)
+	go s.announce(group)
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		s.respond(buf[:n], from)
+	}
+}
+
+// announce Send alive notifications until Close
+func (s *SSDPResponder) announce(group *net.UDPAddr) {
+	for {
+		s.notify(group, "ssdp:alive")
+		select {
+		case <-s.done:
+			return
+		case <-time.After(ssdpMaxAge / 3 * time.Second):
+		}
+	}
+}
+
+// Close Say goodbye and stop responding
+func (s *SSDPResponder) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return
+	}
+	close(s.done)
+	if group, err := net.ResolveUDPAddr("udp4", ssdpGroup); err == nil {
+		s.send(group, "ssdp:byebye")
+	}
+	s.conn.Close()
+	s.conn = nil
+}
+
+func (s *SSDPResponder) notify(group *net.UDPAddr, nts string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.send(group, nts)
+	}
+}
+
+// send NOTIFY every target, caller must hold the lock
+func (s *SSDPResponder) send(group *net.UDPAddr, nts string) {
+	local := localIP(group)
+	for _, target := range ssdpTargets() {
+		message := fmt.Sprintf("NOTIFY * HTTP/1.1\r\nHOST: %s\r\nCACHE-CONTROL: max-age=%d\r\nLOCATION: %s\r\nNT: %s\r\nNTS: %s\r\nSERVER: %s\r\nUSN: %s\r\n\r\n",
+			ssdpGroup, ssdpMaxAge, s.location(local, target.Path), target.ST, nts, ssdpServer, target.USN)
+		if _, err := s.conn.WriteToUDP([]byte(message), group); err != nil {
+			log.Printf("SSDP: could not notify: %s", err)
+			return
+		}
+	}
+}
+
+// localIP Address of the interface used to reach to, nil if there is no route
+func localIP(to *net.UDPAddr) net.IP {
+	conn, err := net.DialUDP("udp4", nil, to)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// location Device description url, reachable from the interface with address local
+func (s *SSDPResponder) location(local net.IP, path string) string {
+	if len(s.baseURL) > 0 {
+		return s.baseURL + path
+	}
+	if local == nil {
+		local = net.IPv4(127, 0, 0, 1)
+	}
+	return fmt.Sprintf("http://%s%s", net.JoinHostPort(local.String(), s.port), path)
+}
+
+// respond Answer an M-SEARCH from a client
+func (s *SSDPResponder) respond(data []byte, from *net.UDPAddr) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != "\"ssdp:discover\"" {
+		return
+	}
+	targets := matchSearch(req.Header.Get("ST"))
+	if len(targets) == 0 {
+		return
+	}
+	// Spread answers over MX seconds so clients are not flooded
+	delay := time.Duration(0)
+	if mx, err := strconv.Atoi(req.Header.Get("MX")); err == nil && mx > 0 {
+		if mx > 5 {
+			mx = 5
+		}
+		delay = time.Duration(rand.Int63n(int64(mx) * int64(time.Second)))
+	}
+	local := localIP(from)
+	go func() {
+		time.Sleep(delay)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.conn == nil {
+			return
+		}
+		for _, target := range targets {
+			message := fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=%d\r\nDATE: %s\r\nEXT:\r\nLOCATION: %s\r\nSERVER: %s\r\nST: %s\r\nUSN: %s\r\n\r\n",
+				ssdpMaxAge, time.Now().UTC().Format(http.TimeFormat), s.location(local, target.Path), ssdpServer, target.ST, target.USN)
+			if _, err := s.conn.WriteToUDP([]byte(message), from); err != nil {
+				log.Printf("SSDP: could not answer %s: %s", from, err)
+				return
+			}
+		}
+		log.Printf("SSDP: answered %s for %s", from, req.Header.Get("ST"))
+	}()
+}
@@ -0,0 +1,69 @@
+package sstv
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestSSDPRespond(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NilError(t, err)
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NilError(t, err)
+	defer client.Close()
+	s := NewSSDPResponder("8080", "")
+	s.conn = server
+	defer s.Close()
+
+	tests := []struct {
+		name    string
+		search  string
+		answers []string
+	}{
+		{"TestBasicDevice", "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nST: " + ssdpBasicDevice + "\r\n\r\n",
+			[]string{"http://127.0.0.1:8080/device.xml"}},
+		{"TestRootDevice", "M-SEARCH * HTTP/1.1\r\nMAN: \"ssdp:discover\"\r\nST: upnp:rootdevice\r\n\r\n",
+			[]string{"http://127.0.0.1:8080/device.xml"}},
+		{"TestMediaServerNotAnnounced", "M-SEARCH * HTTP/1.1\r\nMAN: \"ssdp:discover\"\r\nST: urn:schemas-upnp-org:device:MediaServer:1\r\n\r\n", nil},
+		{"TestOtherTarget", "M-SEARCH * HTTP/1.1\r\nMAN: \"ssdp:discover\"\r\nST: urn:schemas-upnp-org:device:Printer:1\r\n\r\n", nil},
+		{"TestNotASearch", "NOTIFY * HTTP/1.1\r\nNT: upnp:rootdevice\r\n\r\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.respond([]byte(tt.search), client.LocalAddr().(*net.UDPAddr))
+			var locations []string
+			buf := make([]byte, 2048)
+			for {
+				client.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+				n, _, err := client.ReadFromUDP(buf)
+				if err != nil {
+					break
+				}
+				for _, line := range strings.Split(string(buf[:n]), "\r\n") {
+					if strings.HasPrefix(line, "LOCATION: ") {
+						locations = append(locations, strings.TrimPrefix(line, "LOCATION: "))
+					}
+				}
+			}
+			assert.DeepEqual(t, locations, tt.answers)
+		})
+	}
+}
+
+func TestHDHomeRunLineup(t *testing.T) {
+	defer withProviders(&fakeProvider{name: "fake", channels: []ProviderChannel{{ID: "F-1", Key: "1", Name: "One"}, {ID: "F-2", Key: "2", Name: "Two"}}})()
+	w := httptest.NewRecorder()
+	ServeHDHomeRunLineup(RuntimeUtils{})(w, httptest.NewRequest("GET", "http://local/lineup.json", nil))
+	var lineup []HDHomeRunLineupEntry
+	assert.NilError(t, json.NewDecoder(w.Body).Decode(&lineup))
+	assert.DeepEqual(t, lineup, []HDHomeRunLineupEntry{
+		{GuideNumber: "1", GuideName: "One", URL: "http://local/ts/fake/1"},
+		{GuideNumber: "2", GuideName: "Two", URL: "http://local/ts/fake/2"},
+	})
+}
@@ -0,0 +1,250 @@
+package sstv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ssFeedMaxWarnings Warnings kept per parse, the rest are only counted
+const ssFeedMaxWarnings = 50
+
+// SSFeedStats What happened while decoding a sstv feed
+type SSFeedStats struct {
+	Format          string    `json:"format"`
+	Parsed          time.Time `json:"parsed"`
+	Channels        int       `json:"channels"`
+	Events          int       `json:"events"`
+	SkippedChannels int       `json:"skippedChannels"`
+	SkippedEvents   int       `json:"skippedEvents"`
+	Warnings        []string  `json:"warnings"`
+	// DroppedWarnings warnings beyond ssFeedMaxWarnings
+	DroppedWarnings int `json:"droppedWarnings"`
+}
+
+func (s *SSFeedStats) warn(format string, args ...interface{}) {
+	if len(s.Warnings) >= ssFeedMaxWarnings {
+		s.DroppedWarnings++
+		return
+	}
+	s.Warnings = append(s.Warnings, fmt.Sprintf(format, args...))
+}
+
+var ssFeedStatsMu sync.Mutex
+var lastSsFeedStats = make(map[string]SSFeedStats)
+
+// ssFlexString A string field that may also be sent as a number, a bool or null
+type ssFlexString string
+
+func (s *ssFlexString) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*s = ""
+	case len(data) > 0 && data[0] == '"':
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*s = ssFlexString(strings.TrimSpace(str))
+	case bytes.Equal(data, []byte("true")), bytes.Equal(data, []byte("false")):
+		*s = ssFlexString(data)
+	default:
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("expected a string or number, got %s", data)
+		}
+		*s = ssFlexString(n.String())
+	}
+	return nil
+}
+
+// ssFeedEvent Event as sent in the sstv feed
+type ssFeedEvent struct {
+	Name        ssFlexString `json:"name"`
+	Description ssFlexString `json:"description"`
+	Time        ssFlexString `json:"time"`
+	Runtime     ssFlexString `json:"runtime"`
+	Category    ssFlexString `json:"category"`
+}
+
+// ssFeedChannel Channel as sent in the sstv feed, with events as an object or an array
+type ssFeedChannel struct {
+	Number ssFlexString    `json:"number"`
+	Name   ssFlexString    `json:"name"`
+	Img    ssFlexString    `json:"img"`
+	Events json.RawMessage `json:"events"`
+}
+
+// ssFeed Top-level sstv feed, with channels as an object or an array
+type ssFeed struct {
+	Data json.RawMessage `json:"data"`
+}
+
+// jsonCollection Items of a json object, ordered by key, or of a json array. null is empty
+func jsonCollection(data json.RawMessage) ([]json.RawMessage, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil, nil
+	}
+	switch data[0] {
+	case '[':
+		var items []json.RawMessage
+		err := json.Unmarshal(data, &items)
+		return items, err
+	case '{':
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]json.RawMessage, 0, len(keys))
+		for _, key := range keys {
+			items = append(items, object[key])
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("expected an object or array, got %.20s", data)
+}
+
+// decodeSsEvent Validated event, or an error saying why it is skipped
+func decodeSsEvent(data json.RawMessage) (SSEpgEvent, error) {
+	var event ssFeedEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return SSEpgEvent{}, err
+	}
+	if len(event.Name) == 0 {
+		return SSEpgEvent{}, errors.New("no name")
+	}
+	start, err := epochToTime(string(event.Time))
+	if err != nil {
+		return SSEpgEvent{}, fmt.Errorf("invalid time %q", event.Time)
+	}
+	minutes, err := strconv.ParseFloat(string(event.Runtime), 64)
+	if err != nil || minutes <= 0 {
+		return SSEpgEvent{}, fmt.Errorf("invalid runtime %q", event.Runtime)
+	}
+	return SSEpgEvent{
+		Name:        string(event.Name),
+		Description: string(event.Description),
+		Category:    string(event.Category),
+		Start:       start,
+		Stop:        start.Add(time.Duration(minutes * float64(time.Minute))),
+	}, nil
+}
+
+// decodeSsJSONFeed Decode a sstv feed, skipping channels and events that do not validate.
+// Only a feed without any readable channel data is an error
+func decodeSsJSONFeed(data []byte) (SSEpg, SSFeedStats, error) {
+	stats := SSFeedStats{Parsed: time.Now()}
+	var epg SSEpg
+	var feed ssFeed
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return epg, stats, err
+	}
+	channels, err := jsonCollection(feed.Data)
+	if err != nil {
+		return epg, stats, fmt.Errorf("invalid data: %s", err)
+	}
+	if len(channels) == 0 {
+		return epg, stats, errors.New("feed has no channels")
+	}
+
+	for i, channelData := range channels {
+		var channel ssFeedChannel
+		if err := json.Unmarshal(channelData, &channel); err != nil {
+			stats.SkippedChannels++
+			stats.warn("channel %d: %s", i, err)
+			continue
+		}
+		if len(channel.Number) == 0 {
+			stats.SkippedChannels++
+			stats.warn("channel %d: no number", i)
+			continue
+		}
+		name := string(channel.Name)
+		if len(name) == 0 {
+			name = string(channel.Number)
+		}
+		eventsData, err := jsonCollection(channel.Events)
+		if err != nil {
+			stats.warn("channel %s: events: %s", channel.Number, err)
+		}
+		var events []SSEpgEvent
+		for j, eventData := range eventsData {
+			event, err := decodeSsEvent(eventData)
+			if err != nil {
+				stats.SkippedEvents++
+				stats.warn("channel %s event %d: %s", channel.Number, j, err)
+				continue
+			}
+			events = append(events, event)
+		}
+		stats.Channels++
+		stats.Events += len(events)
+		epg.Channels = append(epg.Channels, SSEpgChannel{
+			Number: string(channel.Number),
+			Name:   name,
+			Img:    string(channel.Img),
+			Events: events,
+		})
+	}
+	sortSsChannels(epg.Channels)
+	return epg, stats, nil
+}
+
+// sortSsChannels Sort channels by number, channels without a numeric number go last
+func sortSsChannels(channels []SSEpgChannel) {
+	sort.SliceStable(channels, func(i, j int) bool {
+		a, err1 := strconv.Atoi(channels[i].Number)
+		b, err2 := strconv.Atoi(channels[j].Number)
+		if err1 != nil || err2 != nil {
+			return err1 == nil && err2 != nil
+		}
+		return a < b
+	})
+}
+
+// recordSsFeedStats Log and keep the stats of the last parse of a format
+func recordSsFeedStats(stats SSFeedStats) {
+	log.Printf("sstv %s feed: %d channels, %d events, skipped %d channels and %d events",
+		stats.Format, stats.Channels, stats.Events, stats.SkippedChannels, stats.SkippedEvents)
+	for _, warning := range stats.Warnings {
+		log.Printf("sstv %s feed: %s", stats.Format, warning)
+	}
+	ssFeedStatsMu.Lock()
+	defer ssFeedStatsMu.Unlock()
+	lastSsFeedStats[stats.Format] = stats
+}
+
+// ServeAPIFeedStats Serve the stats of the last parse of every sstv feed format
+func ServeAPIFeedStats(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ssFeedStatsMu.Lock()
+		var stats []SSFeedStats
+		for _, s := range lastSsFeedStats {
+			stats = append(stats, s)
+		}
+		ssFeedStatsMu.Unlock()
+		if len(stats) == 0 {
+			writeJSONError(w, http.StatusNotFound, errors.New("the sstv feed has not been parsed yet"))
+			return
+		}
+		sort.Slice(stats, func(i, j int) bool {
+			return stats[i].Format < stats[j].Format
+		})
+		writeJSON(w, http.StatusOK, stats)
+	}
+}
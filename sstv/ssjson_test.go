@@ -0,0 +1,64 @@
+package sstv
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestDecodeSsJSONFeed(t *testing.T) {
+	feed := `{"data": {
+		"2": {"number": 2, "name": "SS 02", "img": null, "events": [
+			{"name": "NHL", "time": 1550000000, "runtime": 90, "category": "Ice Hockey"},
+			{"name": "No time", "time": "", "runtime": "30"},
+			{"name": "Bad runtime", "time": "1550000000", "runtime": "long"}
+		]},
+		"1": {"number": "01", "name": "SS 01", "img": "logo", "events": {
+			"a": {"name": "F1", "description": "Race", "time": "1550000000", "runtime": "120"},
+			"b": {"time": "1550000000", "runtime": "10"}
+		}},
+		"3": {"number": "03", "name": "SS 03", "events": "none"},
+		"4": {"name": "No number", "events": []},
+		"5": {"number": {"odd": true}}
+	}}`
+	epg, stats, err := decodeSsJSONFeed([]byte(feed))
+	assert.NilError(t, err)
+	start := time.Unix(1550000000, 0)
+	// Numbers keep their leading zeros, tvg-ids and channel keys are built from them
+	assert.DeepEqual(t, epg, SSEpg{Channels: []SSEpgChannel{
+		{Number: "01", Name: "SS 01", Img: "logo", Events: []SSEpgEvent{
+			{Name: "F1", Description: "Race", Start: start, Stop: start.Add(2 * time.Hour)},
+		}},
+		{Number: "2", Name: "SS 02", Events: []SSEpgEvent{
+			{Name: "NHL", Category: "Ice Hockey", Start: start, Stop: start.Add(90 * time.Minute)},
+		}},
+		{Number: "03", Name: "SS 03"},
+	}})
+	assert.Equal(t, stats.Channels, 3)
+	assert.Equal(t, stats.Events, 2)
+	assert.Equal(t, stats.SkippedChannels, 2)
+	assert.Equal(t, stats.SkippedEvents, 3)
+	assert.Equal(t, len(stats.Warnings), 6)
+
+	tests := []struct {
+		name string
+		feed string
+		err  string
+	}{
+		{"NotJSON", "<html>", "invalid character"},
+		{"NoData", `{"error": "down"}`, "no channels"},
+		{"DataArray", `{"data": [{"number": "1"}]}`, ""},
+		{"DataString", `{"data": "x"}`, "invalid data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeSsJSONFeed([]byte(tt.feed))
+			if len(tt.err) == 0 {
+				assert.NilError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
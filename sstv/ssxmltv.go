@@ -0,0 +1,174 @@
+package sstv
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ssNumberRe = regexp.MustCompile(`\d+`)
+
+// ssXMLTVChannel Channel as sent in the sstv XMLTV feed
+type ssXMLTVChannel struct {
+	ID           string   `xml:"id,attr"`
+	DisplayNames []string `xml:"display-name"`
+	Icon         struct {
+		Src string `xml:"src,attr"`
+	} `xml:"icon"`
+}
+
+// ssXMLTVProgramme Programme as sent in the sstv XMLTV feed
+type ssXMLTVProgramme struct {
+	Start      string   `xml:"start,attr"`
+	Stop       string   `xml:"stop,attr"`
+	Channel    string   `xml:"channel,attr"`
+	Title      string   `xml:"title"`
+	Desc       string   `xml:"desc"`
+	Categories []string `xml:"category"`
+}
+
+// ssXMLTVFeed Top-level sstv XMLTV feed
+type ssXMLTVFeed struct {
+	XMLName   xml.Name           `xml:"tv"`
+	Channels  []ssXMLTVChannel   `xml:"channel"`
+	Programme []ssXMLTVProgramme `xml:"programme"`
+}
+
+// ssChannelNumber Channel number without leading zeros, so channels of the feed formats can be matched
+func ssChannelNumber(s string) string {
+	if n, err := strconv.Atoi(s); err == nil {
+		return strconv.Itoa(n)
+	}
+	return s
+}
+
+// ssXMLTVNumber Channel number of a XMLTV channel, from its id or a display name like "01 - ESPN"
+func ssXMLTVNumber(channel ssXMLTVChannel) string {
+	if number := ssNumberRe.FindString(channel.ID); len(number) > 0 && number == strings.TrimSpace(channel.ID) {
+		return ssChannelNumber(number)
+	}
+	for _, name := range channel.DisplayNames {
+		if number := ssNumberRe.FindString(name); len(number) > 0 && strings.HasPrefix(strings.TrimSpace(name), number) {
+			return ssChannelNumber(number)
+		}
+	}
+	return ssChannelNumber(ssNumberRe.FindString(channel.ID))
+}
+
+// ssXMLTVName Display name of a XMLTV channel without its number prefix
+func ssXMLTVName(channel ssXMLTVChannel, number string) string {
+	prefix := regexp.MustCompile(fmt.Sprintf(`^0*%s\s*[-:.]?\s+`, regexp.QuoteMeta(number)))
+	for _, name := range channel.DisplayNames {
+		name = strings.TrimSpace(prefix.ReplaceAllString(strings.TrimSpace(name)+" ", ""))
+		if len(name) > 0 && name != number {
+			return name
+		}
+	}
+	return number
+}
+
+// decodeSsXMLTVFeed Decode a sstv XMLTV feed into SSEpg, skipping channels and programmes that do not validate
+func decodeSsXMLTVFeed(data []byte) (SSEpg, SSFeedStats, error) {
+	stats := SSFeedStats{Parsed: time.Now()}
+	var epg SSEpg
+	var feed ssXMLTVFeed
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		return epg, stats, err
+	}
+	if len(feed.Channels) == 0 {
+		return epg, stats, errors.New("feed has no channels")
+	}
+
+	index := make(map[string]int)
+	for i, channel := range feed.Channels {
+		number := ssXMLTVNumber(channel)
+		if len(number) == 0 {
+			stats.SkippedChannels++
+			stats.warn("channel %d (%s): no number", i, channel.ID)
+			continue
+		}
+		if _, ok := index[channel.ID]; ok {
+			stats.SkippedChannels++
+			stats.warn("channel %s: duplicate id", channel.ID)
+			continue
+		}
+		index[channel.ID] = len(epg.Channels)
+		epg.Channels = append(epg.Channels, SSEpgChannel{
+			Number: number,
+			Name:   ssXMLTVName(channel, number),
+			Img:    channel.Icon.Src,
+		})
+	}
+
+	for i, prog := range feed.Programme {
+		ci, ok := index[prog.Channel]
+		if !ok {
+			stats.SkippedEvents++
+			stats.warn("programme %d: unknown channel %q", i, prog.Channel)
+			continue
+		}
+		event, err := decodeSsXMLTVProgramme(prog)
+		if err != nil {
+			stats.SkippedEvents++
+			stats.warn("channel %s programme %d: %s", prog.Channel, i, err)
+			continue
+		}
+		epg.Channels[ci].Events = append(epg.Channels[ci].Events, event)
+	}
+	for _, channel := range epg.Channels {
+		stats.Channels++
+		stats.Events += len(channel.Events)
+	}
+	sortSsChannels(epg.Channels)
+	return epg, stats, nil
+}
+
+// decodeSsXMLTVProgramme Validated event of a programme, or an error saying why it is skipped
+func decodeSsXMLTVProgramme(prog ssXMLTVProgramme) (SSEpgEvent, error) {
+	title := strings.TrimSpace(prog.Title)
+	if len(title) == 0 {
+		return SSEpgEvent{}, errors.New("no title")
+	}
+	start, err := parseXMLTVTime(prog.Start)
+	if err != nil {
+		return SSEpgEvent{}, fmt.Errorf("invalid start %q", prog.Start)
+	}
+	stop, err := parseXMLTVTime(prog.Stop)
+	if err != nil || !stop.After(start) {
+		return SSEpgEvent{}, fmt.Errorf("invalid stop %q", prog.Stop)
+	}
+	event := SSEpgEvent{
+		Name:        title,
+		Description: strings.TrimSpace(prog.Desc),
+		Start:       start,
+		Stop:        stop,
+	}
+	if len(prog.Categories) > 0 {
+		event.Category = strings.TrimSpace(prog.Categories[0])
+	}
+	return event, nil
+}
+
+// mergeSsEpg Add channels of extra missing from epg, and events to channels of epg that have none
+func mergeSsEpg(epg SSEpg, extra SSEpg) SSEpg {
+	index := make(map[string]int)
+	for i, channel := range epg.Channels {
+		index[ssChannelNumber(channel.Number)] = i
+	}
+	for _, channel := range extra.Channels {
+		i, ok := index[ssChannelNumber(channel.Number)]
+		if !ok {
+			epg.Channels = append(epg.Channels, channel)
+			continue
+		}
+		if len(epg.Channels[i].Events) == 0 {
+			epg.Channels[i].Events = channel.Events
+		}
+	}
+	sortSsChannels(epg.Channels)
+	return epg
+}
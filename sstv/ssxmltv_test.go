@@ -0,0 +1,83 @@
+package sstv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+const ssXMLTVFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<tv>
+	<channel id="1"><display-name>01 - ESPNNews</display-name><icon src="logo1"/></channel>
+	<channel id="ch2"><display-name>02 - ESPN</display-name></channel>
+	<channel id="sky"><display-name>Sky</display-name></channel>
+	<programme start="20190212190000 +0000" stop="20190212210000 +0000" channel="1">
+		<title>NBA: Lakers vs Celtics</title><desc>Basketball</desc><category>Basketball</category><category>Sports</category>
+	</programme>
+	<programme start="20190212190000 +0100" stop="20190212200000 +0100" channel="ch2"><title>F1</title></programme>
+	<programme start="20190212200000 +0000" stop="20190212190000 +0000" channel="ch2"><title>Backwards</title></programme>
+	<programme start="20190212190000 +0000" stop="20190212200000 +0000" channel="nope"><title>Lost</title></programme>
+</tv>`
+
+func TestDecodeSsXMLTVFeed(t *testing.T) {
+	epg, stats, err := decodeSsXMLTVFeed([]byte(ssXMLTVFixture))
+	assert.NilError(t, err)
+	start := time.Date(2019, 2, 12, 19, 0, 0, 0, time.UTC)
+	assert.Equal(t, len(epg.Channels), 2)
+	assert.Equal(t, epg.Channels[0].Number, "1")
+	assert.Equal(t, epg.Channels[0].Name, "ESPNNews")
+	assert.Equal(t, epg.Channels[0].Img, "logo1")
+	assert.Equal(t, len(epg.Channels[0].Events), 1)
+	event := epg.Channels[0].Events[0]
+	assert.Equal(t, event.Name, "NBA: Lakers vs Celtics")
+	assert.Equal(t, event.Category, "Basketball")
+	assert.Assert(t, event.Start.Equal(start))
+	assert.Assert(t, event.Stop.Equal(start.Add(2*time.Hour)))
+	assert.Equal(t, epg.Channels[1].Number, "2")
+	assert.Equal(t, epg.Channels[1].Name, "ESPN")
+	assert.Assert(t, epg.Channels[1].Events[0].Start.Equal(start.Add(-time.Hour)))
+	assert.Equal(t, stats.SkippedChannels, 1)
+	assert.Equal(t, stats.SkippedEvents, 2)
+
+	_, _, err = decodeSsXMLTVFeed([]byte("<tv></tv>"))
+	assert.ErrorContains(t, err, "no channels")
+}
+
+func TestGetSsEpgFallsBack(t *testing.T) {
+	feeds := map[string]string{
+		"ssJsonEpgFeed":  `{"data": "broken"}`,
+		"ssXMLTVEpgFeed": ssXMLTVFixture,
+	}
+	runtime := RuntimeUtils{Cache: &FakeCache{
+		GetFunc: func(key string) (string, error) {
+			if feed, ok := feeds[key]; ok {
+				return feed, nil
+			}
+			return "", fmt.Errorf("no %s", key)
+		},
+	}}
+	c := make(chan SSEpg)
+	go getSsEpg(runtime, c)
+	epg := <-c
+	assert.Equal(t, len(epg.Channels), 2)
+	assert.Equal(t, epg.Channels[0].Name, "ESPNNews")
+}
+
+func TestMergeSsEpg(t *testing.T) {
+	event := SSEpgEvent{Name: "F1"}
+	merged := mergeSsEpg(
+		SSEpg{Channels: []SSEpgChannel{{Number: "02", Name: "json 2"}, {Number: "03", Events: []SSEpgEvent{{Name: "json"}}}}},
+		SSEpg{Channels: []SSEpgChannel{
+			{Number: "1", Name: "xmltv 1"},
+			{Number: "2", Name: "xmltv 2", Events: []SSEpgEvent{event}},
+			{Number: "3", Events: []SSEpgEvent{event}},
+		}},
+	)
+	assert.DeepEqual(t, merged, SSEpg{Channels: []SSEpgChannel{
+		{Number: "1", Name: "xmltv 1"},
+		{Number: "02", Name: "json 2", Events: []SSEpgEvent{event}},
+		{Number: "03", Events: []SSEpgEvent{{Name: "json"}}},
+	}})
+}
@@ -0,0 +1,407 @@
+package sstv
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// timeshiftIdle How long a buffer started on demand keeps running without requests
+const timeshiftIdle = 10 * time.Minute
+
+// timeshiftWait How long a playlist request waits for a new buffer, within the server write timeout
+const timeshiftWait = 8 * time.Second
+
+// timeshiftNoProgramme How long a channel without a current programme is remembered
+const timeshiftNoProgramme = time.Minute
+
+// timeshiftSegment A buffered segment and when it aired
+type timeshiftSegment struct {
+	Sequence      int64
+	Start         time.Time
+	Duration      float64
+	Discontinuity bool
+	path          string
+}
+
+// End When the segment stopped airing
+func (s timeshiftSegment) End() time.Time {
+	return s.Start.Add(time.Duration(s.Duration * float64(time.Second)))
+}
+
+// timeshiftBuffer Rolling buffer of one channel
+type timeshiftBuffer struct {
+	dir        string
+	keep       bool
+	cancel     context.CancelFunc
+	mu         sync.Mutex
+	segments   []timeshiftSegment
+	sequence   int64
+	lastAccess time.Time
+	// account upstream account the buffer streams from, empty if none
+	account string
+}
+
+// timeshiftAiring The programme airing on a channel from start until stop, found is false if there is none
+type timeshiftAiring struct {
+	start time.Time
+	stop  time.Time
+	found bool
+}
+
+// Timeshift Rolling segment buffers of live channels for start-over playback
+type Timeshift struct {
+	runtime *RuntimeUtils
+	dir     string
+	depth   time.Duration
+	mu      sync.Mutex
+	buffers map[string]*timeshiftBuffer
+	airing  map[string]timeshiftAiring
+}
+
+// NewTimeshift Timeshift keeping depth of every buffered channel in dir
+func NewTimeshift(runtime *RuntimeUtils, dir string, depth time.Duration) (*Timeshift, error) {
+	// Buffers do not survive restarts
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Timeshift{
+		runtime: runtime,
+		dir:     dir,
+		depth:   depth,
+		buffers: make(map[string]*timeshiftBuffer),
+		airing:  make(map[string]timeshiftAiring),
+	}, nil
+}
+
+// buffer The running buffer of a channel, started if needed
+func (ts *Timeshift) buffer(provider Provider, key string) *timeshiftBuffer {
+	name := fmt.Sprintf("%s/%s", provider.Name(), key)
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if buf, ok := ts.buffers[name]; ok {
+		buf.mu.Lock()
+		buf.lastAccess = time.Now()
+		buf.mu.Unlock()
+		return buf
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	buf := &timeshiftBuffer{
+		dir:        filepath.Join(ts.dir, fmt.Sprintf("%s_%s", provider.Name(), key)),
+		account:    providerAccount(provider),
+		cancel:     cancel,
+		lastAccess: time.Now(),
+	}
+	ts.buffers[name] = buf
+	go ts.fill(ctx, buf, provider, key)
+	log.Printf("Timeshift: buffering %s", name)
+	return buf
+}
+
+// lookup The running buffer of a channel, never starting one
+func (ts *Timeshift) lookup(provider string, key string) (*timeshiftBuffer, bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	buf, ok := ts.buffers[fmt.Sprintf("%s/%s", provider, key)]
+	if ok {
+		buf.mu.Lock()
+		buf.lastAccess = time.Now()
+		buf.mu.Unlock()
+	}
+	return buf, ok
+}
+
+// running Whether a channel is being buffered
+func (ts *Timeshift) running(provider string, key string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	_, ok := ts.buffers[fmt.Sprintf("%s/%s", provider, key)]
+	return ok
+}
+
+// Keep Buffer channels, by tvg-id, for as long as the server runs
+func (ts *Timeshift) Keep(channels []string) {
+	for _, id := range channels {
+		provider, channel, err := findProviderChannel(*ts.runtime, id)
+		if err != nil {
+			log.Printf("Timeshift: no channel found for %s", id)
+			continue
+		}
+		buf := ts.buffer(provider, channel.Key)
+		buf.mu.Lock()
+		buf.keep = true
+		buf.mu.Unlock()
+	}
+}
+
+// activeOn Number of running buffers streaming from an upstream account
+func (ts *Timeshift) activeOn(account string) int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	count := 0
+	for _, buf := range ts.buffers {
+		if buf.account == account {
+			count++
+		}
+	}
+	return count
+}
+
+// Run Stop idle buffers, forever
+func (ts *Timeshift) Run() {
+	for {
+		time.Sleep(time.Minute)
+		ts.expire(time.Now())
+	}
+}
+
+// expire Stop buffers started on demand that have not been requested since timeshiftIdle before now
+func (ts *Timeshift) expire(now time.Time) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for name, buf := range ts.buffers {
+		buf.mu.Lock()
+		idle := !buf.keep && now.Sub(buf.lastAccess) > timeshiftIdle
+		buf.mu.Unlock()
+		if idle {
+			log.Printf("Timeshift: stopping idle buffer %s", name)
+			buf.cancel()
+			delete(ts.buffers, name)
+		}
+	}
+}
+
+// fill Buffer a channel until ctx is cancelled
+func (ts *Timeshift) fill(ctx context.Context, buf *timeshiftBuffer, provider Provider, key string) {
+	defer os.RemoveAll(buf.dir)
+	if err := os.MkdirAll(buf.dir, 0755); err != nil {
+		log.Printf("Timeshift: %s", err)
+		return
+	}
+	resolve := func() (string, error) {
+		return provider.StreamURL(*ts.runtime, key)
+	}
+	client := upstreamClient(*ts.runtime, provider, key, hlsClient)
+	followHLS(ctx, client, fmt.Sprintf("Timeshift %s/%s", provider.Name(), key), resolve, func(segment HLSSegment) error {
+		buf.mu.Lock()
+		sequence := buf.sequence
+		buf.sequence++
+		buf.mu.Unlock()
+
+		path := filepath.Join(buf.dir, fmt.Sprintf("%d.ts", sequence))
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		_, err = fetchSegment(client, segment.URL, f)
+		f.Close()
+		if err != nil {
+			os.Remove(path)
+			return err
+		}
+		buf.add(timeshiftSegment{
+			Sequence:      sequence,
+			Duration:      segment.Duration,
+			Discontinuity: segment.Discontinuity,
+			path:          path,
+		}, segment.ProgramDateTime, ts.depth)
+		return nil
+	})
+}
+
+// add Append a segment, timing it after the previous one, and drop segments older than depth
+func (buf *timeshiftBuffer) add(segment timeshiftSegment, programDateTime time.Time, depth time.Duration) {
+	now := time.Now()
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	switch {
+	case !programDateTime.IsZero():
+		segment.Start = programDateTime
+	case len(buf.segments) > 0 && !segment.Discontinuity:
+		segment.Start = buf.segments[len(buf.segments)-1].End()
+	default:
+		segment.Start = now.Add(-time.Duration(segment.Duration * float64(time.Second)))
+	}
+	buf.segments = append(buf.segments, segment)
+
+	for len(buf.segments) > 0 && buf.segments[0].End().Before(now.Add(-depth)) {
+		os.Remove(buf.segments[0].path)
+		buf.segments = buf.segments[1:]
+	}
+}
+
+// since Buffered segments ending after start
+func (buf *timeshiftBuffer) since(start time.Time) []timeshiftSegment {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	var result []timeshiftSegment
+	for _, segment := range buf.segments {
+		if segment.End().After(start) {
+			result = append(result, segment)
+		}
+	}
+	return result
+}
+
+// segment A buffered segment by sequence
+func (buf *timeshiftBuffer) segment(sequence int64) (timeshiftSegment, bool) {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	for _, segment := range buf.segments {
+		if segment.Sequence == sequence {
+			return segment, true
+		}
+	}
+	return timeshiftSegment{}, false
+}
+
+// catchupAttributes m3u catch-up attributes for a channel, empty when timeshift is disabled
+func catchupAttributes(runtime RuntimeUtils, baseURL string, provider Provider, channel ProviderChannel) string {
+	if runtime.Timeshift == nil {
+		return ""
+	}
+	days := int(math.Ceil(runtime.Timeshift.depth.Hours() / 24))
+	return fmt.Sprintf(" catchup=\"default\" catchup-days=\"%d\" catchup-source=\"%s/timeshift/%s/%s.m3u8?start={utc}\"",
+		days, baseURL, provider.Name(), channel.Key)
+}
+
+// currentProgrammeStart Start of the programme airing on a channel, or now if there is none.
+// The programme is remembered until it ends so playlist requests do not rebuild the guide
+func (ts *Timeshift) currentProgrammeStart(channelID string, now time.Time) time.Time {
+	ts.mu.Lock()
+	airing, ok := ts.airing[channelID]
+	ts.mu.Unlock()
+	if !ok || airing.start.After(now) || !airing.stop.After(now) {
+		airing = timeshiftAiring{start: now, stop: now.Add(timeshiftNoProgramme)}
+		for _, prog := range getAPIGuide(*ts.runtime, "").Programmes[channelID] {
+			if !prog.Start.After(now) && prog.Stop.After(now) {
+				airing = timeshiftAiring{start: prog.Start, stop: prog.Stop, found: true}
+				break
+			}
+		}
+		ts.mu.Lock()
+		ts.airing[channelID] = airing
+		ts.mu.Unlock()
+	}
+	if !airing.found {
+		return now
+	}
+	return airing.start
+}
+
+// timeshiftChannel Provider and channel of the request, writing a 404 if there is none
+func timeshiftChannel(runtime RuntimeUtils, w http.ResponseWriter, r *http.Request) (Provider, ProviderChannel, bool) {
+	vars := mux.Vars(r)
+	if runtime.Timeshift != nil {
+		if provider, ok := GetProvider(vars["provider"]); ok {
+			channels, _ := provider.Channels(runtime)
+			for _, channel := range channels {
+				if channel.Key == vars["chan"] {
+					return provider, channel, true
+				}
+			}
+		}
+	}
+	w.WriteHeader(404)
+	w.Write([]byte(fmt.Sprintf("No timeshift channel found for %s/%s", vars["provider"], vars["chan"])))
+	return nil, ProviderChannel{}, false
+}
+
+// ServeTimeshiftPlaylist Serve the buffer of a channel from ?start=<unix>, or the start of the current programme
+func ServeTimeshiftPlaylist(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, channel, ok := timeshiftChannel(runtime, w, r)
+		if !ok {
+			return
+		}
+		now := time.Now()
+		var start time.Time
+		if value := r.URL.Query().Get("start"); len(value) > 0 {
+			var err error
+			if start, err = parseAPITime(value, now); err != nil {
+				w.WriteHeader(400)
+				w.Write([]byte(fmt.Sprintf("Invalid start: %s", err)))
+				return
+			}
+		} else {
+			start = runtime.Timeshift.currentProgrammeStart(channel.ID, now)
+		}
+
+		// Starting a buffer opens an upstream connection, so it is subject to the stream limits
+		if runtime.Sessions != nil {
+			if _, err := runtime.Sessions.Start(r, provider, channel.Key, SessionTimeshift); err != nil {
+				writeStreamLimit(w, channel.Key)
+				return
+			}
+		}
+		buf := runtime.Timeshift.buffer(provider, channel.Key)
+		segments := buf.since(start)
+		deadline := time.Now().Add(timeshiftWait)
+		for len(segments) == 0 && time.Now().Before(deadline) {
+			// A buffer that just started has nothing to play yet
+			time.Sleep(500 * time.Millisecond)
+			segments = buf.since(start)
+		}
+		if len(segments) == 0 {
+			w.WriteHeader(503)
+			w.Write([]byte("Buffering, try again"))
+			return
+		}
+
+		target := 1.0
+		for _, segment := range segments {
+			target = math.Max(target, segment.Duration)
+		}
+		baseURL := getBaseURL(r)
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n",
+			int(math.Ceil(target)), segments[0].Sequence)
+		for i, segment := range segments {
+			if segment.Discontinuity && i > 0 {
+				fmt.Fprint(w, "#EXT-X-DISCONTINUITY\n")
+			}
+			fmt.Fprintf(w, "#EXT-X-PROGRAM-DATE-TIME:%s\n#EXTINF:%.3f,\n%s/timeshift/%s/%s/%d.ts\n",
+				segment.Start.UTC().Format("2006-01-02T15:04:05.000Z"), segment.Duration, baseURL, provider.Name(), channel.Key, segment.Sequence)
+		}
+	}
+}
+
+// ServeTimeshiftSegment Serve a buffered segment
+func ServeTimeshiftSegment(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, channel, ok := timeshiftChannel(runtime, w, r)
+		if !ok {
+			return
+		}
+		sequence, _ := strconv.ParseInt(mux.Vars(r)["seq"], 10, 64)
+		var segment timeshiftSegment
+		buf, ok := runtime.Timeshift.lookup(provider.Name(), channel.Key)
+		if ok {
+			segment, ok = buf.segment(sequence)
+		}
+		if !ok {
+			w.WriteHeader(404)
+			w.Write([]byte(fmt.Sprintf("Segment %d is not buffered", sequence)))
+			return
+		}
+		if runtime.Sessions != nil {
+			runtime.Sessions.Touch(r, provider.Name(), channel.Key)
+		}
+		w.Header().Set("Content-Type", "video/mp2t")
+		http.ServeFile(w, r, segment.path)
+	}
+}
@@ -0,0 +1,159 @@
+package sstv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gotest.tools/assert"
+)
+
+func TestTimeshiftBufferAdd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timeshift")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	buf := &timeshiftBuffer{dir: dir}
+	aired := time.Now().Add(-time.Hour)
+	for i := int64(0); i < 3; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%d.ts", i))
+		assert.NilError(t, ioutil.WriteFile(path, []byte("ts"), 0644))
+		var programDateTime time.Time
+		if i == 0 {
+			programDateTime = aired
+		}
+		buf.add(timeshiftSegment{Sequence: i, Duration: 6, path: path}, programDateTime, 2*time.Hour)
+	}
+	assert.Equal(t, len(buf.segments), 3)
+	// Segments without a program date time follow the previous one
+	assert.Equal(t, buf.segments[2].Start, aired.Add(12*time.Second))
+	assert.Equal(t, len(buf.since(aired.Add(7*time.Second))), 2)
+
+	// Segments older than the depth are dropped with their files
+	buf.add(timeshiftSegment{Sequence: 3, Duration: 6, path: filepath.Join(dir, "3.ts")}, time.Now(), 30*time.Minute)
+	assert.Equal(t, len(buf.segments), 1)
+	_, err = os.Stat(filepath.Join(dir, "0.ts"))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestTimeshiftCurrentProgrammeStart(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	restore := withProviders(&fakeProvider{
+		name:     "fake",
+		channels: []ProviderChannel{{ID: "F-1", Key: "1"}},
+		epg:      EPG{Programme: []Programme{fakeProgramme("F-1", "Current", now.Add(-30*time.Minute), 60)}},
+	})
+	defer restore()
+	dir, err := ioutil.TempDir("", "timeshift")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	ts, err := NewTimeshift(&RuntimeUtils{}, dir, time.Hour)
+	assert.NilError(t, err)
+
+	assert.Equal(t, ts.currentProgrammeStart("F-1", now), now.Add(-30*time.Minute))
+	assert.Equal(t, ts.currentProgrammeStart("F-2", now), now)
+
+	// The airing programme is remembered until it ends
+	providers = nil
+	assert.Equal(t, ts.currentProgrammeStart("F-1", now.Add(10*time.Minute)), now.Add(-30*time.Minute))
+	assert.Equal(t, ts.currentProgrammeStart("F-1", now.Add(30*time.Minute)), now.Add(30*time.Minute))
+}
+
+func TestTimeshiftHandlers(t *testing.T) {
+	defer withProviders(&fakeProvider{name: "fake", channels: []ProviderChannel{{ID: "F-1", Key: "1"}}})()
+	dir, err := ioutil.TempDir("", "timeshift")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	ts, err := NewTimeshift(&RuntimeUtils{}, dir, time.Hour)
+	assert.NilError(t, err)
+
+	// A running buffer, so no upstream is followed
+	aired := time.Date(2019, 2, 12, 18, 0, 0, 0, time.UTC)
+	buf := &timeshiftBuffer{dir: dir, cancel: func() {}, lastAccess: time.Now()}
+	for i := int64(0); i < 3; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%d.ts", i))
+		assert.NilError(t, ioutil.WriteFile(path, []byte(fmt.Sprintf("segment %d", i)), 0644))
+		buf.segments = append(buf.segments, timeshiftSegment{
+			Sequence: i,
+			Start:    aired.Add(time.Duration(i) * 6 * time.Second),
+			Duration: 6,
+			path:     path,
+		})
+	}
+	ts.buffers["fake/1"] = buf
+
+	runtime := RuntimeUtils{Timeshift: ts}
+	r := mux.NewRouter()
+	r.HandleFunc("/timeshift/{provider}/{chan}.m3u8", ServeTimeshiftPlaylist(runtime))
+	r.HandleFunc("/timeshift/{provider}/{chan}/{seq:[0-9]+}.ts", ServeTimeshiftSegment(runtime))
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		body   string
+	}{
+		{"TestPlaylistFromStart", fmt.Sprintf("/timeshift/fake/1.m3u8?start=%d", aired.Add(7*time.Second).Unix()), 200,
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:1\n" +
+				"#EXT-X-PROGRAM-DATE-TIME:2019-02-12T18:00:06.000Z\n#EXTINF:6.000,\nhttp://example.com/timeshift/fake/1/1.ts\n" +
+				"#EXT-X-PROGRAM-DATE-TIME:2019-02-12T18:00:12.000Z\n#EXTINF:6.000,\nhttp://example.com/timeshift/fake/1/2.ts\n"},
+		{"TestPlaylistInvalidStart", "/timeshift/fake/1.m3u8?start=soon", 400, ""},
+		{"TestPlaylistUnknownChannel", "/timeshift/fake/2.m3u8", 404, ""},
+		{"TestSegment", "/timeshift/fake/1/2.ts", 200, "segment 2"},
+		{"TestSegmentNotBuffered", "/timeshift/fake/1/7.ts", 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			assert.Equal(t, w.Code, tt.status)
+			if len(tt.body) > 0 {
+				assert.Equal(t, w.Body.String(), tt.body)
+			}
+			assert.Assert(t, !strings.Contains(w.Body.String(), "PLAYLIST-TYPE"))
+		})
+	}
+}
+
+func TestTimeshiftSessionLimits(t *testing.T) {
+	defer withProviders(&accountProvider{fakeProvider{name: "fake", channels: []ProviderChannel{{ID: "F-1", Key: "1"}, {ID: "F-2", Key: "2"}}}})()
+	dir, err := ioutil.TempDir("", "timeshift")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	runtime := RuntimeUtils{}
+	runtime.Timeshift, err = NewTimeshift(&runtime, dir, time.Hour)
+	assert.NilError(t, err)
+	runtime.Sessions = NewSessionTracker(&runtime, 0, 1, time.Hour, time.Minute)
+	// A running buffer holds the only account connection
+	path := filepath.Join(dir, "0.ts")
+	assert.NilError(t, ioutil.WriteFile(path, []byte("segment 0"), 0644))
+	runtime.Timeshift.buffers["fake/1"] = &timeshiftBuffer{dir: dir, account: "account", cancel: func() {}, lastAccess: time.Now(),
+		segments: []timeshiftSegment{{Sequence: 0, Start: time.Now().Add(-time.Minute), Duration: 6, path: path}}}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/timeshift/{provider}/{chan}.m3u8", ServeTimeshiftPlaylist(runtime))
+	r.HandleFunc("/timeshift/{provider}/{chan}/{seq:[0-9]+}.ts", ServeTimeshiftSegment(runtime))
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	// Segments of a channel that is not buffered never start a buffer
+	assert.Equal(t, get("/timeshift/fake/2/0.ts"), 404)
+	assert.Assert(t, !runtime.Timeshift.running("fake", "2"))
+
+	// A new buffer needs an account connection, joining a running one does not
+	assert.Equal(t, get(fmt.Sprintf("/timeshift/fake/2.m3u8?start=%d", time.Now().Add(-time.Hour).Unix())), 429)
+	assert.Assert(t, !runtime.Timeshift.running("fake", "2"))
+	assert.Equal(t, get(fmt.Sprintf("/timeshift/fake/1.m3u8?start=%d", time.Now().Add(-time.Hour).Unix())), 200)
+	assert.Equal(t, len(runtime.Sessions.List()), 1)
+	assert.Equal(t, get("/timeshift/fake/1/0.ts"), 200)
+}
@@ -0,0 +1,113 @@
+package sstv
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// xmltvTimeFormat Timestamps in XMLTV, with the numeric offset of their zone
+const xmltvTimeFormat = "20060102150405 -0700"
+
+// Time correction sources, the keys of EPGTimeCorrections
+const (
+	TimeSourceBase    = "base"
+	TimeSourceSSTV    = "sstv"
+	TimeSourceGrabber = "grab"
+)
+
+// timeCorrection Fixes times of a source that publishes them in the wrong zone.
+// The wall clock is read in zone when set, then shifted
+type timeCorrection struct {
+	zone  *time.Location
+	shift time.Duration
+}
+
+// parseTimeCorrection A correction given as a duration like -1h, or a zone name like America/New_York
+func parseTimeCorrection(s string) (timeCorrection, error) {
+	if shift, err := time.ParseDuration(s); err == nil {
+		return timeCorrection{shift: shift}, nil
+	}
+	zone, err := time.LoadLocation(s)
+	if err != nil {
+		return timeCorrection{}, fmt.Errorf("%q is neither a duration nor a time zone", s)
+	}
+	return timeCorrection{zone: zone}, nil
+}
+
+func (c timeCorrection) apply(t time.Time) time.Time {
+	if c.zone != nil {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), c.zone)
+	}
+	return t.Add(c.shift).UTC()
+}
+
+var timeSettingsOnce sync.Once
+var epgZone = time.UTC
+var timeCorrections = make(map[string]timeCorrection)
+
+// loadTimeSettings Parse the configured output zone and corrections once.
+// GetConfig refuses invalid ones, so they are only logged and ignored here
+func loadTimeSettings() {
+	timeSettingsOnce.Do(func() {
+		cfg := GetConfig()
+		if zone, err := time.LoadLocation(cfg.EPGTimeZone); err == nil {
+			epgZone = zone
+		} else {
+			log.Printf("Invalid EPG time zone %s, using UTC: %s", cfg.EPGTimeZone, err)
+		}
+		for source, value := range cfg.EPGTimeCorrections {
+			correction, err := parseTimeCorrection(value)
+			if err != nil {
+				log.Printf("Ignoring time correction for %s: %s", source, err)
+				continue
+			}
+			timeCorrections[source] = correction
+		}
+	})
+}
+
+// epgLocation Zone EPG times are written in
+func epgLocation() *time.Location {
+	loadTimeSettings()
+	return epgZone
+}
+
+// sourceTimeCorrection Configured correction of a source, none if it has none
+func sourceTimeCorrection(source string) timeCorrection {
+	loadTimeSettings()
+	return timeCorrections[source]
+}
+
+// formatXMLTVTime Format t for XMLTV in zone
+func formatXMLTVTime(t time.Time, zone *time.Location) string {
+	return t.In(zone).Format(xmltvTimeFormat)
+}
+
+// correctProgrammes Apply a correction to XMLTV programme times, writing them in zone.
+// Programmes with times that do not parse are left as they are
+func correctProgrammes(programmes []Programme, correction timeCorrection, zone *time.Location) {
+	for i, prog := range programmes {
+		start, err1 := parseXMLTVTime(prog.Start)
+		stop, err2 := parseXMLTVTime(prog.Stop)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		programmes[i].Start = formatXMLTVTime(correction.apply(start), zone)
+		programmes[i].Stop = formatXMLTVTime(correction.apply(stop), zone)
+	}
+}
+
+// correctSsEpg Apply a correction to the event times of a sstv EPG
+func correctSsEpg(epg SSEpg, correction timeCorrection) {
+	if correction == (timeCorrection{}) {
+		return
+	}
+	for _, channel := range epg.Channels {
+		for i, event := range channel.Events {
+			channel.Events[i].Start = correction.apply(event.Start)
+			channel.Events[i].Stop = correction.apply(event.Stop)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package sstv
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestTimeCorrections(t *testing.T) {
+	reykjavik, err := time.LoadLocation("Atlantic/Reykjavik")
+	assert.NilError(t, err)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name       string
+		correction string
+		zone       *time.Location
+		start      string
+		want       string
+	}{
+		{"NoCorrection", "0s", time.UTC, "20190212190000 +0000", "20190212190000 +0000"},
+		{"OutputZone", "0s", berlin, "20190212190000 +0000", "20190212200000 +0100"},
+		{"OutputZoneDST", "0s", berlin, "20190712190000 +0000", "20190712210000 +0200"},
+		{"Shift", "-90m", time.UTC, "20190212190000 +0000", "20190212173000 +0000"},
+		// Published as UTC but actually New York wall clock
+		{"WrongZone", "America/New_York", time.UTC, "20190212190000 +0000", "20190213000000 +0000"},
+		{"WrongZoneDST", "America/New_York", reykjavik, "20190712190000", "20190712230000 +0000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			correction, err := parseTimeCorrection(tt.correction)
+			assert.NilError(t, err)
+			programmes := []Programme{{Start: tt.start, Stop: tt.start}, {Start: "bad", Stop: "bad"}}
+			correctProgrammes(programmes, correction, tt.zone)
+			assert.Equal(t, programmes[0].Start, tt.want)
+			assert.Equal(t, programmes[1].Start, "bad")
+		})
+	}
+
+	_, err = parseTimeCorrection("Nowhere/Special")
+	assert.ErrorContains(t, err, "neither a duration nor a time zone")
+}
+
+func TestRuleWeekdaysInEPGZone(t *testing.T) {
+	loadTimeSettings()
+	original := epgZone
+	defer func() { epgZone = original }()
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NilError(t, err)
+	epgZone = newYork
+
+	// Saturday in UTC, still Friday evening in New York
+	event := SSEpgEvent{Name: "NHL: Bruins vs Leafs", Start: time.Date(2019, 2, 16, 2, 0, 0, 0, time.UTC)}
+	assert.Assert(t, RecordingRule{Keywords: []string{"nhl"}, Weekdays: []string{"fri"}}.Matches("SSTV-1", event))
+	assert.Assert(t, !RecordingRule{Keywords: []string{"nhl"}, Weekdays: []string{"sat"}}.Matches("SSTV-1", event))
+}
+
+func TestConfigValidateTimeSettings(t *testing.T) {
+	valid := validConfig()
+	valid.EPGTimeZone = "Europe/Berlin"
+	assert.NilError(t, valid.validate())
+
+	badZone := valid
+	badZone.EPGTimeZone = "Nowhere/Special"
+	assert.ErrorContains(t, badZone.validate(), "invalid EPG_TIME_ZONE")
+
+	badCorrection := valid
+	badCorrection.EPGTimeCorrections = map[string]string{"base": "sometime"}
+	assert.ErrorContains(t, badCorrection.validate(), "invalid EPG_TIME_CORRECTIONS for base")
+}
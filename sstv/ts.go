@@ -0,0 +1,204 @@
+package sstv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+)
+
+// tsWriter Writes whole MPEG-TS packets of consecutive segments to a client
+type tsWriter struct {
+	w       http.ResponseWriter
+	pending []byte
+	// reset PIDs whose next adaptation field is flagged as discontinuous
+	reset map[uint16]bool
+	// marking Flag every PID seen until the next segment
+	marking bool
+}
+
+func newTSWriter(w http.ResponseWriter) *tsWriter {
+	return &tsWriter{w: w, reset: make(map[uint16]bool)}
+}
+
+// segment Start a new segment, flagging the packets after a discontinuity
+func (t *tsWriter) segment(discontinuity bool) {
+	// A partial packet at the end of a segment can not be completed by the next one
+	t.pending = nil
+	t.marking = discontinuity
+	if discontinuity {
+		t.reset = make(map[uint16]bool)
+	}
+}
+
+// Write Buffer data and write out complete packets, resyncing on the sync byte
+func (t *tsWriter) Write(data []byte) (int, error) {
+	t.pending = append(t.pending, data...)
+	var out bytes.Buffer
+	for len(t.pending) >= tsPacketSize {
+		if t.pending[0] != tsSyncByte {
+			i := bytes.IndexByte(t.pending, tsSyncByte)
+			if i < 0 {
+				t.pending = nil
+				break
+			}
+			t.pending = t.pending[i:]
+			continue
+		}
+		packet := t.pending[:tsPacketSize]
+		t.mark(packet)
+		out.Write(packet)
+		t.pending = t.pending[tsPacketSize:]
+	}
+	if out.Len() > 0 {
+		if _, err := t.w.Write(out.Bytes()); err != nil {
+			return 0, err
+		}
+		if f, ok := t.w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return len(data), nil
+}
+
+// mark Set the discontinuity indicator on the first adaptation field of each PID after a discontinuity
+func (t *tsWriter) mark(packet []byte) {
+	pid := uint16(packet[1]&0x1f)<<8 | uint16(packet[2])
+	if t.marking {
+		if _, seen := t.reset[pid]; !seen {
+			t.reset[pid] = true
+		}
+	}
+	if !t.reset[pid] {
+		return
+	}
+	// adaptation_field_control 2 or 3 with a non empty field
+	if packet[3]&0x20 != 0 && packet[4] > 0 {
+		packet[5] |= 0x80
+		t.reset[pid] = false
+	}
+}
+
+// disableWriteTimeout Let a streaming response outlive the server write timeout
+func disableWriteTimeout(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		log.Printf("Could not disable write timeout: %s", err)
+	}
+}
+
+// follow Pass each segment of a shared session to handle until ctx is done
+func (p *StreamProxy) follow(ctx context.Context, provider Provider, key string, viewer string, handle func(segment sharedSegment) error) error {
+	last := int64(-1)
+	for ctx.Err() == nil {
+		// A new upstream session continues the sequence of the previous one
+		session := p.session(provider, key)
+		session.touch(viewer)
+		for _, segment := range session.snapshot() {
+			if segment.Sequence <= last {
+				continue
+			}
+			if last >= 0 && segment.Sequence != last+1 {
+				segment.Discontinuity = true
+			}
+			if err := handle(segment); err != nil {
+				return err
+			}
+			last = segment.Sequence
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// ServeTSStream Serve a channel as one continuous MPEG-TS stream
+func ServeTSStream(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		name, ok := vars["provider"]
+		if !ok {
+			name = "sstv"
+		}
+		provider, ok := GetProvider(name)
+		if !ok {
+			w.WriteHeader(404)
+			w.Write([]byte(fmt.Sprintf("No provider found for %s", name)))
+			return
+		}
+		serveTSStream(runtime, w, r, provider, vars["chan"])
+	}
+}
+
+// serveTSStream Stream a channel of a provider as MPEG-TS until the client leaves or the stream ends
+func serveTSStream(runtime RuntimeUtils, w http.ResponseWriter, r *http.Request, provider Provider, key string) {
+	// Resolve once up front so failures get a proper status instead of an empty stream
+	streamURL, err := provider.StreamURL(runtime, key)
+	if err != nil {
+		writeStreamError(w, provider, key, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	if runtime.Sessions != nil {
+		session, err := runtime.Sessions.Start(r, provider, key, SessionTS)
+		if err != nil {
+			writeStreamLimit(w, key)
+			return
+		}
+		runtime.Sessions.attach(session.ID, cancel)
+		defer runtime.Sessions.End(session.ID)
+	}
+
+	disableWriteTimeout(w)
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	out := newTSWriter(w)
+	label := fmt.Sprintf("TS %s/%s", provider.Name(), key)
+	log.Printf("%s: streaming to %s", label, r.RemoteAddr)
+
+	if runtime.Proxy != nil {
+		err = runtime.Proxy.follow(ctx, provider, key, viewerID(r), func(segment sharedSegment) error {
+			out.segment(segment.Discontinuity)
+			_, err := out.Write(segment.Data)
+			if runtime.Sessions != nil {
+				runtime.Sessions.Touch(r, provider.Name(), key)
+			}
+			return err
+		})
+	} else {
+		resolve := func() (string, error) {
+			if len(streamURL) > 0 {
+				resolved := streamURL
+				streamURL = ""
+				return resolved, nil
+			}
+			return provider.StreamURL(runtime, key)
+		}
+		client := upstreamClient(runtime, provider, key, hlsClient)
+		err = followHLS(ctx, client, label, resolve, func(segment HLSSegment) error {
+			out.segment(segment.Discontinuity)
+			_, err := fetchSegment(client, segment.URL, out)
+			if runtime.Sessions != nil {
+				runtime.Sessions.Touch(r, provider.Name(), key)
+			}
+			return err
+		})
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("%s: stream stopped: %s", label, err)
+	}
+	log.Printf("%s: closed stream to %s", label, r.RemoteAddr)
+}
@@ -0,0 +1,80 @@
+package sstv
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"gotest.tools/assert"
+)
+
+// tsPacket A packet of pid with an adaptation field
+func tsPacket(pid uint16, fill byte) []byte {
+	packet := bytes.Repeat([]byte{fill}, tsPacketSize)
+	packet[0] = tsSyncByte
+	packet[1] = byte(pid >> 8)
+	packet[2] = byte(pid)
+	packet[3] = 0x30
+	packet[4] = 1
+	packet[5] = 0
+	return packet
+}
+
+func TestTSWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	out := newTSWriter(w)
+
+	out.segment(false)
+	// Garbage before the first packet and a partial packet at the end are dropped
+	out.Write([]byte{1, 2, 3})
+	out.Write(tsPacket(256, 1))
+	out.Write(tsPacket(257, 2)[:100])
+	assert.Equal(t, w.Body.Len(), tsPacketSize)
+
+	out.segment(true)
+	out.Write(append(tsPacket(256, 3), tsPacket(256, 4)...))
+	out.segment(false)
+	out.Write(tsPacket(256, 5))
+
+	body := w.Body.Bytes()
+	assert.Equal(t, len(body), 4*tsPacketSize)
+	assert.Equal(t, body[5]&0x80, byte(0))
+	assert.Equal(t, body[tsPacketSize+5]&0x80, byte(0x80))
+	assert.Equal(t, body[2*tsPacketSize+5]&0x80, byte(0))
+	assert.Equal(t, body[3*tsPacketSize+5]&0x80, byte(0))
+}
+
+func TestServeTSStream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/live.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:1.0,\na.ts\n#EXTINF:1.0,\nb.ts\n#EXT-X-ENDLIST\n"))
+		case "/a.ts":
+			w.Write(tsPacket(256, 1))
+		default:
+			w.Write(tsPacket(256, 2))
+		}
+	}))
+	defer upstream.Close()
+	defer withProviders(&staticProvider{channels: []StaticChannel{
+		{ProviderChannel{ID: "T", Key: "t", Name: "Test"}, []string{upstream.URL + "/live.m3u8"}},
+	}})()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/ts/{provider}/{chan}", ServeTSStream(RuntimeUtils{}))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/ts/static/t", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Header().Get("Content-Type"), "video/mp2t")
+	body := w.Body.Bytes()
+	assert.Equal(t, len(body), 2*tsPacketSize)
+	assert.Equal(t, body[6], byte(1))
+	assert.Equal(t, body[tsPacketSize+6], byte(2))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/ts/static/nope", nil))
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,7 +15,37 @@ func epochToTime(s string) (time.Time, error) {
 	if err != nil {
 		return time.Time{}, err
 	}
-	return time.Unix(sec, 0), nil
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// parseXMLTVTime Parse an XMLTV timestamp, with or without offset
+func parseXMLTVTime(s string) (time.Time, error) {
+	if t, err := time.Parse("20060102150405 -0700", s); err == nil {
+		return t, nil
+	}
+	return time.Parse("20060102150405", s)
+}
+
+// m3uLine Keeps a m3u value on its line
+var m3uLine = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// m3uAttr Value for a quoted #EXTINF attribute, on one line and without double quotes
+func m3uAttr(s string) string {
+	return strings.ReplaceAll(m3uLine.Replace(s), "\"", "'")
+}
+
+// m3uTitle Title after the #EXTINF comma, on one line
+func m3uTitle(s string) string {
+	return m3uLine.Replace(s)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 func cache(client CacheClient, key string, value string, minutes int64) error {
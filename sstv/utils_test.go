@@ -25,6 +25,7 @@ func TestEpochToTime(t *testing.T) {
 	if !got.Equal(cmp) {
 		t.Errorf("DT not equals: %s and %s", got.Format(FormatString), cmp.Format(FormatString))
 	}
+	assert.Equal(t, got.Location(), time.UTC)
 }
 
 func TestEpochToTimeReturnsErrorForNonNumber(t *testing.T) {
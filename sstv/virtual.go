@@ -0,0 +1,213 @@
+package sstv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrNotAiring returned when a channel has nothing to stream right now
+var ErrNotAiring = errors.New("nothing airing")
+
+// VirtualChannel A channel following events matching its rules across sstv channels
+type VirtualChannel struct {
+	Name       string   `json:"name"`
+	Logo       string   `json:"logo"`
+	Keywords   []string `json:"keywords"`
+	Categories []string `json:"categories"`
+}
+
+// virtualEvent A matching event and the sstv channel it airs on
+type virtualEvent struct {
+	Channel SSEpgChannel
+	Event   SSEpgEvent
+}
+
+// Key Stable url and tvg-id safe name
+func (v VirtualChannel) Key() string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, v.Name), "-")
+}
+
+// ID tvg-id of the virtual channel
+func (v VirtualChannel) ID() string {
+	return fmt.Sprintf("VIRTUAL-%s", v.Key())
+}
+
+// Matches Whether the event name contains a keyword or the event is in one of the categories
+func (v VirtualChannel) Matches(event SSEpgEvent) bool {
+	name := strings.ToLower(event.Name)
+	for _, keyword := range v.Keywords {
+		if len(keyword) > 0 && strings.Contains(name, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	for _, category := range v.Categories {
+		if len(category) > 0 && strings.EqualFold(event.Category, category) {
+			return true
+		}
+	}
+	return false
+}
+
+// schedule Matching events without overlaps, an event airing keeps the channel until it stops
+func (v VirtualChannel) schedule(epg SSEpg) []virtualEvent {
+	var matches []virtualEvent
+	for _, channel := range epg.Channels {
+		for _, event := range channel.Events {
+			if v.Matches(event) {
+				matches = append(matches, virtualEvent{Channel: channel, Event: event})
+			}
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Event.Start.Before(matches[j].Event.Start)
+	})
+
+	var result []virtualEvent
+	for _, match := range matches {
+		if len(result) > 0 {
+			last := result[len(result)-1]
+			if !match.Event.Stop.After(last.Event.Stop) {
+				continue
+			}
+			if match.Event.Start.Before(last.Event.Stop) {
+				match.Event.Start = last.Event.Stop
+			}
+		}
+		result = append(result, match)
+	}
+	return result
+}
+
+// airing The scheduled event airing at t
+func (v VirtualChannel) airing(epg SSEpg, t time.Time) (virtualEvent, bool) {
+	for _, ve := range v.schedule(epg) {
+		if !ve.Event.Start.After(t) && ve.Event.Stop.After(t) {
+			return ve, true
+		}
+	}
+	return virtualEvent{}, false
+}
+
+// LoadVirtualChannels Read virtual channel definitions from a json file
+func LoadVirtualChannels(path string) ([]VirtualChannel, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var channels []VirtualChannel
+	if err := json.Unmarshal(data, &channels); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	for _, channel := range channels {
+		if len(channel.Key()) == 0 {
+			return nil, fmt.Errorf("virtual channel without name")
+		}
+		if len(channel.Keywords) == 0 && len(channel.Categories) == 0 {
+			return nil, fmt.Errorf("virtual channel %s has no keywords or categories", channel.Name)
+		}
+		if keys[channel.Key()] {
+			return nil, fmt.Errorf("duplicate virtual channel %s", channel.Name)
+		}
+		keys[channel.Key()] = true
+	}
+	return channels, nil
+}
+
+// NewVirtualProvider Provider serving virtual channels on /v/{chan}
+func NewVirtualProvider(channels []VirtualChannel) Provider {
+	return &virtualProvider{channels: channels}
+}
+
+type virtualProvider struct {
+	channels []VirtualChannel
+}
+
+func (p *virtualProvider) Name() string {
+	return "virtual"
+}
+
+// Account virtual channels stream from sstv
+func (p *virtualProvider) Account() string {
+	return GetConfig().Username
+}
+
+// ChannelPath virtual channels are served on /v/{chan}
+func (p *virtualProvider) ChannelPath(channel string) string {
+	return fmt.Sprintf("/v/%s", channel)
+}
+
+func (p *virtualProvider) channel(key string) (VirtualChannel, bool) {
+	for _, channel := range p.channels {
+		if channel.Key() == key {
+			return channel, true
+		}
+	}
+	return VirtualChannel{}, false
+}
+
+func (p *virtualProvider) Channels(runtime RuntimeUtils) ([]ProviderChannel, error) {
+	var result []ProviderChannel
+	for _, channel := range p.channels {
+		result = append(result, ProviderChannel{
+			ID:   channel.ID(),
+			Key:  channel.Key(),
+			Name: channel.Name,
+			Logo: channel.Logo,
+		})
+	}
+	return result, nil
+}
+
+func (p *virtualProvider) StreamURL(runtime RuntimeUtils, channel string) (string, error) {
+	virtual, ok := p.channel(channel)
+	if !ok {
+		return "", ErrUnknownChannel
+	}
+	epgChan := make(chan SSEpg)
+	go getSsEpg(runtime, epgChan)
+
+	ve, ok := virtual.airing(<-epgChan, time.Now())
+	if !ok {
+		return "", ErrNotAiring
+	}
+	upstream, ok := GetProvider("sstv")
+	if !ok {
+		return "", errors.New("sstv provider not registered")
+	}
+	return upstream.StreamURL(runtime, ve.Channel.Number)
+}
+
+func (p *virtualProvider) EPG(runtime RuntimeUtils) (EPG, error) {
+	epgChan := make(chan SSEpg)
+	go getSsEpg(runtime, epgChan)
+	epg := <-epgChan
+
+	var result EPG
+	for _, virtual := range p.channels {
+		result.Channel = append(result.Channel, Channel{
+			ID: virtual.ID(),
+			DisplayName: TextLang{
+				Lang: "en",
+				Text: virtual.Name,
+			},
+		})
+		for _, ve := range virtual.schedule(epg) {
+			event := ve.Event
+			event.Description = strings.TrimSpace(fmt.Sprintf("%s (%s)", event.Description, ve.Channel.Name))
+			result.Programme = append(result.Programme, ssEventToProgramme(virtual.ID(), event))
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package sstv
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestVirtualChannel(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	event := func(name string, category string, start int, minutes int) SSEpgEvent {
+		return SSEpgEvent{
+			Name:     name,
+			Category: category,
+			Start:    now.Add(time.Duration(start) * time.Minute),
+			Stop:     now.Add(time.Duration(start+minutes) * time.Minute),
+		}
+	}
+	epg := SSEpg{Channels: []SSEpgChannel{
+		{Number: "01", Name: "SS 01", Events: []SSEpgEvent{
+			event("NFL: Bears vs Packers", "American Football", -60, 90),
+			event("Formula 1: Qualifying", "Motorsport", 60, 60),
+		}},
+		{Number: "02", Name: "SS 02", Events: []SSEpgEvent{
+			event("NFL: Giants vs Jets", "American Football", 0, 180),
+			event("NFL Redzone", "", 10, 20),
+		}},
+	}}
+	nfl := VirtualChannel{Name: "NFL Games!", Keywords: []string{"nfl"}}
+
+	assert.Equal(t, nfl.Key(), "nfl-games")
+	assert.Equal(t, nfl.ID(), "VIRTUAL-nfl-games")
+
+	schedule := nfl.schedule(epg)
+	assert.Equal(t, len(schedule), 2)
+	assert.Equal(t, schedule[0].Channel.Number, "01")
+	assert.Equal(t, schedule[1].Channel.Number, "02")
+	assert.Equal(t, schedule[1].Event.Start, now.Add(30*time.Minute))
+
+	ve, ok := nfl.airing(epg, now.Add(15*time.Minute))
+	assert.Assert(t, ok)
+	assert.Equal(t, ve.Channel.Number, "01")
+	ve, ok = nfl.airing(epg, now.Add(45*time.Minute))
+	assert.Assert(t, ok)
+	assert.Equal(t, ve.Channel.Number, "02")
+
+	f1 := VirtualChannel{Name: "F1", Categories: []string{"motorsport"}}
+	_, ok = f1.airing(epg, now)
+	assert.Assert(t, !ok)
+	ve, ok = f1.airing(epg, now.Add(90*time.Minute))
+	assert.Assert(t, ok)
+	assert.Equal(t, ve.Event.Name, "Formula 1: Qualifying")
+}
@@ -0,0 +1,188 @@
+package sstv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultVODCategory = "Recordings"
+
+// VODSegment A captured segment within a recording file
+type VODSegment struct {
+	Offset        int64
+	Length        int64
+	Duration      float64
+	Discontinuity bool
+}
+
+// segmentIndexFile Name of the segment index kept next to a recording file
+func segmentIndexFile(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ".idx"
+}
+
+func writeSegmentIndex(w io.Writer, segment VODSegment) error {
+	_, err := fmt.Fprintf(w, "%d %d %f %t\n", segment.Offset, segment.Length, segment.Duration, segment.Discontinuity)
+	return err
+}
+
+// readSegmentIndex Read the segments of a recording, skipping lines that do not parse
+func readSegmentIndex(path string) ([]VODSegment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var result []VODSegment
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var segment VODSegment
+		_, err := fmt.Sscanf(scanner.Text(), "%d %d %f %t", &segment.Offset, &segment.Length, &segment.Duration, &segment.Discontinuity)
+		if err == nil {
+			result = append(result, segment)
+		}
+	}
+	return result, scanner.Err()
+}
+
+// Library Completed recordings with a file, newest first
+func (rec *Recorder) Library() []Recording {
+	var result []Recording
+	for _, r := range rec.List() {
+		if r.Status == RecordingCompleted && len(r.File) > 0 {
+			result = append(result, r)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Start.After(result[j].Start)
+	})
+	return result
+}
+
+// VODCategory Category a recording is listed under
+func (r Recording) VODCategory() string {
+	if len(r.Category) > 0 {
+		return r.Category
+	}
+	return defaultVODCategory
+}
+
+// vodDuration Total duration of a recording in seconds, -1 if unknown
+func (rec *Recorder) vodDuration(r Recording) float64 {
+	segments, err := readSegmentIndex(filepath.Join(rec.dir, segmentIndexFile(r.File)))
+	if err != nil || len(segments) == 0 {
+		return -1
+	}
+	var total float64
+	for _, segment := range segments {
+		total += segment.Duration
+	}
+	return total
+}
+
+// getVODm3u m3u of all completed recordings, grouped by category
+func getVODm3u(runtime RuntimeUtils, c chan string, baseURL string) {
+	defer close(c)
+	c <- fmt.Sprintf("#EXTM3U x-tvg-url=\"%s/g\"\n", baseURL)
+	for _, r := range runtime.Recorder.Library() {
+		name := fmt.Sprintf("%s (%s)", r.Title, r.Start.Format("2006-01-02 15:04"))
+		c <- fmt.Sprintf("#EXTINF:%d tvg-id=\"%s\" tvg-name=\"%s\" group-title=\"%s\", %s\n",
+			int(math.Round(runtime.Recorder.vodDuration(r))), m3uAttr(r.Channel), m3uAttr(r.Title), m3uAttr(r.VODCategory()), m3uTitle(name))
+		c <- fmt.Sprintf("%s/vod/%s.m3u8\n", baseURL, r.ID)
+	}
+}
+
+// ServeVODList Serve completed recordings as a m3u playlist
+func ServeVODList(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if runtime.Recorder == nil {
+			w.WriteHeader(404)
+			w.Write([]byte("Recording is not enabled"))
+			return
+		}
+		c := make(chan string)
+		go getVODm3u(runtime, c, getBaseURL(r))
+		emitChannelDataToWriter(c, w)
+	}
+}
+
+// vodRecording Completed recording for the {id} of the request
+func vodRecording(runtime RuntimeUtils, w http.ResponseWriter, r *http.Request) (Recording, bool) {
+	id := mux.Vars(r)["id"]
+	if runtime.Recorder != nil {
+		if recording, ok := runtime.Recorder.Get(id); ok && recording.Status == RecordingCompleted && len(recording.File) > 0 {
+			return recording, true
+		}
+	}
+	w.WriteHeader(404)
+	w.Write([]byte(fmt.Sprintf("No recording found for %s", id)))
+	return Recording{}, false
+}
+
+// ServeVODPlaylist Serve a recording as a seekable HLS VOD playlist of byte ranges
+func ServeVODPlaylist(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recording, ok := vodRecording(runtime, w, r)
+		if !ok {
+			return
+		}
+		segments, err := readSegmentIndex(filepath.Join(runtime.Recorder.dir, segmentIndexFile(recording.File)))
+		if err != nil || len(segments) == 0 {
+			// Without an index the whole file is one segment
+			segments = []VODSegment{{Length: recording.Bytes, Duration: recording.RecordUntil.Sub(recording.RecordFrom).Seconds()}}
+		}
+		target := 1.0
+		for _, segment := range segments {
+			target = math.Max(target, segment.Duration)
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n", int(math.Ceil(target)))
+		for i, segment := range segments {
+			if segment.Discontinuity && i > 0 {
+				fmt.Fprint(w, "#EXT-X-DISCONTINUITY\n")
+			}
+			fmt.Fprintf(w, "#EXTINF:%.3f,\n#EXT-X-BYTERANGE:%d@%d\n%s.ts\n", segment.Duration, segment.Length, segment.Offset, recording.ID)
+		}
+		fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+	}
+}
+
+// ServeVODFile Serve a recording file with range support
+func ServeVODFile(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recording, ok := vodRecording(runtime, w, r)
+		if !ok {
+			return
+		}
+		serveVODFile(runtime, w, r, recording)
+	}
+}
+
+func serveVODFile(runtime RuntimeUtils, w http.ResponseWriter, r *http.Request, recording Recording) {
+	f, err := os.Open(filepath.Join(runtime.Recorder.dir, recording.File))
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	// Recordings take longer than the server write timeout to download
+	disableWriteTimeout(w)
+	w.Header().Set("Content-Type", "video/mp2t")
+	http.ServeContent(w, r, recording.File, stat.ModTime(), f)
+}
@@ -0,0 +1,502 @@
+package sstv
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const xtreamTimeFormat = "2006-01-02 15:04:05"
+const defaultShortEPGLimit = 4
+
+// Profile An Xtream Codes login and the providers it may watch
+type Profile struct {
+	Username string
+	Password string
+	// Providers the profile sees, all when empty
+	Providers []string
+}
+
+// allows Whether the profile may watch channels of a provider
+func (p Profile) allows(provider string) bool {
+	return len(p.Providers) == 0 || containsString(p.Providers, provider)
+}
+
+// LoadProfiles Read profiles from a json file
+func LoadProfiles(path string) ([]Profile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profiles []Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, err
+	}
+	usernames := make(map[string]bool)
+	for _, profile := range profiles {
+		if len(profile.Username) == 0 || len(profile.Password) == 0 {
+			return nil, fmt.Errorf("profile without username or password")
+		}
+		if usernames[profile.Username] {
+			return nil, fmt.Errorf("duplicate profile %s", profile.Username)
+		}
+		usernames[profile.Username] = true
+	}
+	return profiles, nil
+}
+
+// xtreamLogin Profile matching a username and password
+func xtreamLogin(runtime RuntimeUtils, username string, password string) (Profile, bool) {
+	for _, profile := range runtime.Profiles {
+		if profile.Username == username && subtle.ConstantTimeCompare([]byte(profile.Password), []byte(password)) == 1 {
+			return profile, true
+		}
+	}
+	return Profile{}, false
+}
+
+// xtreamAuth Profile of the request credentials, writing an error if there is none
+func xtreamAuth(runtime RuntimeUtils, w http.ResponseWriter, username string, password string) (Profile, bool) {
+	if len(runtime.Profiles) == 0 {
+		w.WriteHeader(404)
+		w.Write([]byte("Xtream API is not enabled"))
+		return Profile{}, false
+	}
+	profile, ok := xtreamLogin(runtime, username, password)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"user_info": map[string]int{"auth": 0}})
+		return Profile{}, false
+	}
+	return profile, true
+}
+
+// xtreamID Stable numeric id, as Xtream clients expect numbers
+func xtreamID(value string) int {
+	return int(crc32.ChecksumIEEE([]byte(value)) & 0x7fffffff)
+}
+
+// xtreamStream A live channel as listed to Xtream clients. There are no Xtream catch-up endpoints, so TVArchive stays 0
+type xtreamStream struct {
+	Num               int    `json:"num"`
+	Name              string `json:"name"`
+	StreamType        string `json:"stream_type"`
+	StreamID          int    `json:"stream_id"`
+	StreamIcon        string `json:"stream_icon"`
+	EPGChannelID      string `json:"epg_channel_id"`
+	Added             string `json:"added"`
+	CategoryID        string `json:"category_id"`
+	CustomSID         string `json:"custom_sid"`
+	TVArchive         int    `json:"tv_archive"`
+	DirectSource      string `json:"direct_source"`
+	TVArchiveDuration int    `json:"tv_archive_duration"`
+	provider          Provider
+	key               string
+	headers           map[string]string
+}
+
+// xtreamCategory A live or VOD category
+type xtreamCategory struct {
+	CategoryID   string `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	ParentID     int    `json:"parent_id"`
+}
+
+// xtreamLiveStreams Channels of the providers a profile may watch, in playlist order
+func xtreamLiveStreams(runtime RuntimeUtils, profile Profile) []xtreamStream {
+	var result []xtreamStream
+	for _, p := range Providers() {
+		if !profile.allows(p.Name()) {
+			continue
+		}
+		channels, err := playlistChannels(runtime, p)
+		if err != nil {
+			log.Printf("Provider %s: could not list channels: %s", p.Name(), err)
+			continue
+		}
+		for _, channel := range channels {
+			result = append(result, xtreamStream{
+				Num:          len(result) + 1,
+				Name:         channel.Name,
+				StreamType:   "live",
+				StreamID:     xtreamID(fmt.Sprintf("%s/%s", p.Name(), channel.Key)),
+				StreamIcon:   channel.Logo,
+				EPGChannelID: channel.ID,
+				Added:        "0",
+				CategoryID:   strconv.Itoa(xtreamID(p.Name())),
+				provider:     p,
+				key:          channel.Key,
+				headers:      channel.Headers,
+			})
+		}
+	}
+	return result
+}
+
+// xtreamLiveStream A live channel by stream id
+func xtreamLiveStream(runtime RuntimeUtils, profile Profile, id string) (xtreamStream, bool) {
+	for _, stream := range xtreamLiveStreams(runtime, profile) {
+		if strconv.Itoa(stream.StreamID) == id {
+			return stream, true
+		}
+	}
+	return xtreamStream{}, false
+}
+
+// xtreamVODStream A recording as listed to Xtream clients
+type xtreamVODStream struct {
+	Num                int    `json:"num"`
+	Name               string `json:"name"`
+	StreamType         string `json:"stream_type"`
+	StreamID           int    `json:"stream_id"`
+	StreamIcon         string `json:"stream_icon"`
+	Rating             string `json:"rating"`
+	Added              string `json:"added"`
+	CategoryID         string `json:"category_id"`
+	ContainerExtension string `json:"container_extension"`
+	CustomSID          string `json:"custom_sid"`
+	DirectSource       string `json:"direct_source"`
+}
+
+func toXtreamVODStream(num int, r Recording) xtreamVODStream {
+	return xtreamVODStream{
+		Num:                num,
+		Name:               fmt.Sprintf("%s (%s)", r.Title, r.Start.Format("2006-01-02 15:04")),
+		StreamType:         "movie",
+		StreamID:           xtreamID(r.ID),
+		Added:              strconv.FormatInt(r.Start.Unix(), 10),
+		CategoryID:         strconv.Itoa(xtreamID(r.VODCategory())),
+		ContainerExtension: "ts",
+	}
+}
+
+// xtreamRecording A completed recording by stream id
+func xtreamRecording(runtime RuntimeUtils, id string) (Recording, bool) {
+	if runtime.Recorder == nil {
+		return Recording{}, false
+	}
+	for _, r := range runtime.Recorder.Library() {
+		if strconv.Itoa(xtreamID(r.ID)) == id {
+			return r, true
+		}
+	}
+	return Recording{}, false
+}
+
+// xtreamListing A programme in Xtream EPG responses, texts base64 encoded
+type xtreamListing struct {
+	ID             string `json:"id"`
+	EPGID          string `json:"epg_id"`
+	Title          string `json:"title"`
+	Lang           string `json:"lang"`
+	Start          string `json:"start"`
+	End            string `json:"end"`
+	Description    string `json:"description"`
+	ChannelID      string `json:"channel_id"`
+	StartTimestamp string `json:"start_timestamp"`
+	StopTimestamp  string `json:"stop_timestamp"`
+	NowPlaying     int    `json:"now_playing"`
+	HasArchive     int    `json:"has_archive"`
+}
+
+func toXtreamListing(stream xtreamStream, prog APIProgramme, now time.Time) xtreamListing {
+	listing := xtreamListing{
+		ID:             strconv.Itoa(xtreamID(fmt.Sprintf("%s/%d", prog.Channel, prog.Start.Unix()))),
+		EPGID:          strconv.Itoa(stream.StreamID),
+		Title:          base64.StdEncoding.EncodeToString([]byte(prog.Title)),
+		Start:          prog.Start.UTC().Format(xtreamTimeFormat),
+		End:            prog.Stop.UTC().Format(xtreamTimeFormat),
+		Description:    base64.StdEncoding.EncodeToString([]byte(prog.Description)),
+		ChannelID:      prog.Channel,
+		StartTimestamp: strconv.FormatInt(prog.Start.Unix(), 10),
+		StopTimestamp:  strconv.FormatInt(prog.Stop.Unix(), 10),
+	}
+	if !prog.Start.After(now) && prog.Stop.After(now) {
+		listing.NowPlaying = 1
+	}
+	return listing
+}
+
+// xtreamListings Programmes of a stream, only upcoming ones up to limit when short
+func xtreamListings(runtime RuntimeUtils, stream xtreamStream, short bool, limit int) []xtreamListing {
+	now := time.Now()
+	result := []xtreamListing{}
+	for _, prog := range getAPIGuide(runtime, "").Programmes[stream.EPGChannelID] {
+		if short && !prog.Stop.After(now) {
+			continue
+		}
+		if short && len(result) >= limit {
+			break
+		}
+		result = append(result, toXtreamListing(stream, prog, now))
+	}
+	return result
+}
+
+// xtreamAccountInfo The login response of player_api.php
+func xtreamAccountInfo(runtime RuntimeUtils, r *http.Request, profile Profile) map[string]interface{} {
+	now := time.Now()
+	base, _ := url.Parse(getBaseURL(r))
+	protocol, port := base.Scheme, base.Port()
+	if len(port) == 0 {
+		port = "80"
+		if protocol == "https" {
+			port = "443"
+		}
+	}
+	active := 0
+	if runtime.Sessions != nil {
+		active = len(runtime.Sessions.List())
+	}
+	return map[string]interface{}{
+		"user_info": map[string]interface{}{
+			"username":               profile.Username,
+			"password":               profile.Password,
+			"message":                "",
+			"auth":                   1,
+			"status":                 "Active",
+			"exp_date":               nil,
+			"is_trial":               "0",
+			"active_cons":            strconv.Itoa(active),
+			"created_at":             strconv.FormatInt(now.Unix(), 10),
+			"max_connections":        strconv.Itoa(GetConfig().AccountConnectionLimit),
+			"allowed_output_formats": []string{"m3u8", "ts"},
+		},
+		"server_info": map[string]interface{}{
+			"url":             base.Hostname(),
+			"port":            port,
+			"https_port":      port,
+			"server_protocol": protocol,
+			"rtmp_port":       "0",
+			"timezone":        "UTC",
+			"timestamp_now":   now.Unix(),
+			"time_now":        now.UTC().Format(xtreamTimeFormat),
+		},
+	}
+}
+
+// ServeXtreamPlayerAPI Serve player_api.php for Xtream Codes clients
+func ServeXtreamPlayerAPI(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		profile, ok := xtreamAuth(runtime, w, query.Get("username"), query.Get("password"))
+		if !ok {
+			return
+		}
+
+		switch query.Get("action") {
+		case "":
+			writeJSON(w, http.StatusOK, xtreamAccountInfo(runtime, r, profile))
+		case "get_live_categories":
+			categories := []xtreamCategory{}
+			for _, p := range Providers() {
+				if profile.allows(p.Name()) {
+					categories = append(categories, xtreamCategory{CategoryID: strconv.Itoa(xtreamID(p.Name())), CategoryName: p.Name()})
+				}
+			}
+			writeJSON(w, http.StatusOK, categories)
+		case "get_live_streams":
+			streams := []xtreamStream{}
+			for _, stream := range xtreamLiveStreams(runtime, profile) {
+				if category := query.Get("category_id"); len(category) == 0 || category == stream.CategoryID {
+					streams = append(streams, stream)
+				}
+			}
+			writeJSON(w, http.StatusOK, streams)
+		case "get_short_epg", "get_simple_data_table":
+			stream, ok := xtreamLiveStream(runtime, profile, query.Get("stream_id"))
+			if !ok {
+				writeJSONError(w, http.StatusNotFound, fmt.Errorf("no stream found for %s", query.Get("stream_id")))
+				return
+			}
+			limit := defaultShortEPGLimit
+			if value := query.Get("limit"); len(value) > 0 {
+				limit, _ = strconv.Atoi(value)
+			}
+			short := query.Get("action") == "get_short_epg"
+			writeJSON(w, http.StatusOK, map[string][]xtreamListing{"epg_listings": xtreamListings(runtime, stream, short, limit)})
+		case "get_vod_categories":
+			categories := []xtreamCategory{}
+			if runtime.Recorder != nil {
+				seen := make(map[string]bool)
+				for _, rec := range runtime.Recorder.Library() {
+					if !seen[rec.VODCategory()] {
+						seen[rec.VODCategory()] = true
+						categories = append(categories, xtreamCategory{CategoryID: strconv.Itoa(xtreamID(rec.VODCategory())), CategoryName: rec.VODCategory()})
+					}
+				}
+				sort.Slice(categories, func(i, j int) bool {
+					return categories[i].CategoryName < categories[j].CategoryName
+				})
+			}
+			writeJSON(w, http.StatusOK, categories)
+		case "get_vod_streams":
+			streams := []xtreamVODStream{}
+			if runtime.Recorder != nil {
+				for _, rec := range runtime.Recorder.Library() {
+					stream := toXtreamVODStream(len(streams)+1, rec)
+					if category := query.Get("category_id"); len(category) == 0 || category == stream.CategoryID {
+						streams = append(streams, stream)
+					}
+				}
+			}
+			writeJSON(w, http.StatusOK, streams)
+		case "get_vod_info":
+			rec, ok := xtreamRecording(runtime, query.Get("vod_id"))
+			if !ok {
+				writeJSONError(w, http.StatusNotFound, fmt.Errorf("no recording found for %s", query.Get("vod_id")))
+				return
+			}
+			duration := int(math.Max(0, runtime.Recorder.vodDuration(rec)))
+			stream := toXtreamVODStream(1, rec)
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"info": map[string]interface{}{
+					"name":          stream.Name,
+					"plot":          rec.Description,
+					"description":   rec.Description,
+					"genre":         rec.Category,
+					"releasedate":   rec.Start.Format("2006-01-02"),
+					"duration_secs": duration,
+					"duration":      fmt.Sprintf("%02d:%02d:%02d", duration/3600, duration/60%60, duration%60),
+				},
+				"movie_data": stream,
+			})
+		case "get_series_categories", "get_series":
+			writeJSON(w, http.StatusOK, []interface{}{})
+		default:
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unknown action %s", query.Get("action")))
+		}
+	}
+}
+
+// getXtreamm3u m3u of the live streams of a profile, linked through the Xtream stream urls
+func getXtreamm3u(runtime RuntimeUtils, c chan string, baseURL string, profile Profile, output string) {
+	defer close(c)
+	c <- fmt.Sprintf("#EXTM3U x-tvg-url=\"%s/xmltv.php?username=%s&password=%s\"\n",
+		baseURL, url.QueryEscape(profile.Username), url.QueryEscape(profile.Password))
+	for _, stream := range xtreamLiveStreams(runtime, profile) {
+		c <- fmt.Sprintf("#EXTINF:-1 tvg-id=\"%s\" tvg-name=\"%s\" tvg-logo=\"%s\" group-title=\"%s\", %s\n",
+			m3uAttr(stream.EPGChannelID), m3uAttr(stream.Name), m3uAttr(stream.StreamIcon), m3uAttr(stream.provider.Name()), m3uTitle(stream.Name))
+		// ts streams are fetched here, only m3u8 redirects leave the upstream request to the player
+		if output == "m3u8" {
+			c <- playerHeaderHints(runtime, stream.headers)
+		}
+		c <- fmt.Sprintf("%s/live/%s/%s/%d.%s\n", baseURL, url.PathEscape(profile.Username), url.PathEscape(profile.Password), stream.StreamID, output)
+	}
+}
+
+// ServeXtreamPlaylist Serve get.php, the m3u of a profile
+func ServeXtreamPlaylist(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		profile, ok := xtreamAuth(runtime, w, query.Get("username"), query.Get("password"))
+		if !ok {
+			return
+		}
+		output := query.Get("output")
+		if output != "m3u8" {
+			output = "ts"
+		}
+		c := make(chan string)
+		go getXtreamm3u(runtime, c, getBaseURL(r), profile, output)
+		emitChannelDataToWriter(c, w)
+	}
+}
+
+// ServeXtreamEPG Serve xmltv.php, the guide of /g limited to the channels the profile may watch
+func ServeXtreamEPG(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		profile, ok := xtreamAuth(runtime, w, query.Get("username"), query.Get("password"))
+		if !ok {
+			return
+		}
+		if len(profile.Providers) == 0 {
+			ServeEPG(runtime)(w, r)
+			return
+		}
+		writeEPG(w, xtreamEPG(runtime, profile))
+	}
+}
+
+// xtreamEPG Channels and programmes of the guide on channels of the providers a profile may watch
+func xtreamEPG(runtime RuntimeUtils, profile Profile) EPG {
+	allowed := make(map[string]bool)
+	for _, p := range Providers() {
+		if !profile.allows(p.Name()) {
+			continue
+		}
+		channels, err := p.Channels(runtime)
+		if err != nil {
+			log.Printf("Provider %s: could not list channels: %s", p.Name(), err)
+			continue
+		}
+		for _, channel := range channels {
+			allowed[channel.ID] = true
+		}
+	}
+
+	guide := getGuide(runtime)
+	var epg EPG
+	for _, channel := range guide.Channel {
+		if allowed[channel.ID] {
+			epg.Channel = append(epg.Channel, channel)
+		}
+	}
+	for _, prog := range guide.Programme {
+		if allowed[prog.Channel] {
+			epg.Programme = append(epg.Programme, prog)
+		}
+	}
+	return epg
+}
+
+// ServeXtreamLive Serve /live/{username}/{password}/{id}.{ext} as a redirect (m3u8) or continuous stream (ts)
+func ServeXtreamLive(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		profile, ok := xtreamAuth(runtime, w, vars["username"], vars["password"])
+		if !ok {
+			return
+		}
+		stream, ok := xtreamLiveStream(runtime, profile, vars["id"])
+		if !ok {
+			w.WriteHeader(404)
+			w.Write([]byte(fmt.Sprintf("No stream found for %s", vars["id"])))
+			return
+		}
+		if vars["ext"] == "m3u8" {
+			serveProviderStream(runtime, w, r, stream.provider, stream.key)
+			return
+		}
+		serveTSStream(runtime, w, r, stream.provider, stream.key)
+	}
+}
+
+// ServeXtreamMovie Serve /movie/{username}/{password}/{id}.{ext} from the recordings
+func ServeXtreamMovie(runtime RuntimeUtils) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if _, ok := xtreamAuth(runtime, w, vars["username"], vars["password"]); !ok {
+			return
+		}
+		recording, ok := xtreamRecording(runtime, vars["id"])
+		if !ok {
+			w.WriteHeader(404)
+			w.Write([]byte(fmt.Sprintf("No recording found for %s", vars["id"])))
+			return
+		}
+		serveVODFile(runtime, w, r, recording)
+	}
+}
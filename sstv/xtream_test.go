@@ -0,0 +1,115 @@
+package sstv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gotest.tools/assert"
+)
+
+func TestXtreamAPI(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	defer withProviders(
+		&fakeProvider{
+			name:     "fake",
+			channels: []ProviderChannel{{ID: "F-1", Key: "1", Name: "Sports One", Logo: "http://logo/1.png"}},
+			epg: EPG{Programme: []Programme{
+				fakeProgramme("F-1", "Past", now.Add(-90*time.Minute), 60),
+				fakeProgramme("F-1", "Current", now.Add(-30*time.Minute), 60),
+				fakeProgramme("F-1", "Later", now.Add(30*time.Minute), 60),
+			}},
+		},
+		&fakeProvider{
+			name:     "hidden",
+			channels: []ProviderChannel{{ID: "H-1", Key: "1", Name: "Hidden"}},
+			epg: EPG{
+				Channel:   []Channel{{ID: "H-1", DisplayName: TextLang{Text: "Hidden"}}},
+				Programme: []Programme{fakeProgramme("H-1", "Secret", now, 60)},
+			},
+		},
+	)()
+	// Timeshift buffers are not offered as Xtream archive
+	runtime := RuntimeUtils{Profiles: []Profile{{Username: "user", Password: "pass", Providers: []string{"fake"}}}, Timeshift: &Timeshift{depth: 24 * time.Hour}}
+	router := mux.NewRouter()
+	router.HandleFunc("/player_api.php", ServeXtreamPlayerAPI(runtime))
+	router.HandleFunc("/get.php", ServeXtreamPlaylist(runtime))
+	router.HandleFunc("/xmltv.php", ServeXtreamEPG(runtime))
+	router.HandleFunc("/live/{username}/{password}/{id:[0-9]+}.{ext:ts|m3u8}", ServeXtreamLive(runtime))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "http://local"+path, nil))
+		return w
+	}
+	streamID := xtreamID("fake/1")
+
+	w := get("/player_api.php?username=user&password=wrong")
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+
+	w = get("/player_api.php?username=user&password=pass")
+	assert.Equal(t, w.Code, http.StatusOK)
+	var login struct {
+		UserInfo struct {
+			Auth int
+		} `json:"user_info"`
+		ServerInfo struct {
+			URL  string
+			Port string
+		} `json:"server_info"`
+	}
+	assert.NilError(t, json.NewDecoder(w.Body).Decode(&login))
+	assert.Equal(t, login.UserInfo.Auth, 1)
+	assert.Equal(t, login.ServerInfo.URL, "local")
+	assert.Equal(t, login.ServerInfo.Port, "80")
+
+	w = get("/player_api.php?username=user&password=pass&action=get_live_categories")
+	var categories []xtreamCategory
+	assert.NilError(t, json.NewDecoder(w.Body).Decode(&categories))
+	assert.DeepEqual(t, categories, []xtreamCategory{{CategoryID: strconv.Itoa(xtreamID("fake")), CategoryName: "fake"}})
+
+	w = get("/player_api.php?username=user&password=pass&action=get_live_streams")
+	var streams []xtreamStream
+	assert.NilError(t, json.NewDecoder(w.Body).Decode(&streams))
+	assert.Equal(t, len(streams), 1)
+	assert.Equal(t, streams[0].StreamID, streamID)
+	assert.Equal(t, streams[0].TVArchive, 0)
+	assert.Equal(t, streams[0].EPGChannelID, "F-1")
+
+	w = get(fmt.Sprintf("/player_api.php?username=user&password=pass&action=get_short_epg&stream_id=%d&limit=1", streamID))
+	var epg struct {
+		Listings []xtreamListing `json:"epg_listings"`
+	}
+	assert.NilError(t, json.NewDecoder(w.Body).Decode(&epg))
+	assert.Equal(t, len(epg.Listings), 1)
+	title, _ := base64.StdEncoding.DecodeString(epg.Listings[0].Title)
+	assert.Equal(t, string(title), "Current")
+	assert.Equal(t, epg.Listings[0].NowPlaying, 1)
+
+	w = get(fmt.Sprintf("/player_api.php?username=user&password=pass&action=get_simple_data_table&stream_id=%d", streamID))
+	assert.NilError(t, json.NewDecoder(w.Body).Decode(&epg))
+	assert.Equal(t, len(epg.Listings), 3)
+
+	w = get("/get.php?username=user&password=pass&type=m3u_plus&output=m3u8")
+	body := w.Body.String()
+	assert.Assert(t, strings.Contains(body, fmt.Sprintf("http://local/live/user/pass/%d.m3u8\n", streamID)), body)
+	assert.Assert(t, !strings.Contains(body, "Hidden"), body)
+
+	w = get("/xmltv.php?username=user&password=pass")
+	body = w.Body.String()
+	assert.Assert(t, strings.Contains(body, "Current"), body)
+	assert.Assert(t, !strings.Contains(body, "H-1"), body)
+
+	w = get(fmt.Sprintf("/live/user/pass/%d.m3u8", streamID))
+	assert.Equal(t, w.Code, http.StatusFound)
+	assert.Equal(t, w.Header().Get("Location"), "http://upstream/1.m3u8")
+
+	w = get(fmt.Sprintf("/live/user/pass/%d.m3u8", xtreamID("hidden/1")))
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}